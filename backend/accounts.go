@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Estado de las cuentas. Eliminar un usuario es un borrado lógico (deleted_at):
+// deja de poder entrar y desaparece de los listados, pero sus intentos y
+// estadísticas se conservan y un admin puede restaurarlo. La purga es una
+// operación aparte que borra la fila y todo su historial. La suspensión tiene
+// motivo y, opcionalmente, fecha de fin; sin fecha es un baneo indefinido.
+
+type Suspension struct {
+	Reason string     `json:"reason"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until"`
+	By     *int       `json:"by,omitempty"`
+}
+
+type accountState struct {
+	Deleted    bool
+	Suspension *Suspension
+}
+
+// Condición SQL de suspensión vigente (las vencidas dejan de aplicarse solas)
+const suspensionActiveSQL = `suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > CURRENT_TIMESTAMP)`
+
+// Consultar si la cuenta está eliminada o suspendida; una fila inexistente
+// (cuenta purgada) cuenta como eliminada
+
+func loadAccountState(ctx context.Context, userID int) (accountState, error) {
+	var st accountState
+	var active bool
+	var since, until sql.NullTime
+	var reason string
+	err := DB.QueryRowContext(ctx, `
+		SELECT deleted_at IS NOT NULL, `+suspensionActiveSQL+`, suspended_at, suspended_until, COALESCE(suspension_reason, '')
+		FROM users WHERE id = $1`, userID).Scan(&st.Deleted, &active, &since, &until, &reason)
+	if err == sql.ErrNoRows {
+		return accountState{Deleted: true}, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if active {
+		st.Suspension = &Suspension{Reason: reason, Since: since.Time, Until: nullTimePtr(until)}
+	}
+	return st, nil
+}
+
+// Suspensión vigente de un usuario (nil si no tiene), bloqueando su fila hasta
+// el fin de la transacción; sql.ErrNoRows si no existe
+
+func loadSuspensionTx(tx *sql.Tx, userID int) (*Suspension, error) {
+	var active bool
+	var since, until sql.NullTime
+	var by sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT `+suspensionActiveSQL+`, suspended_at, suspended_until, suspended_by
+		FROM users WHERE id = $1
+		FOR UPDATE`, userID).Scan(&active, &since, &until, &by)
+	if err != nil || !active {
+		return nil, err
+	}
+	s := &Suspension{Since: since.Time, Until: nullTimePtr(until)}
+	if by.Valid {
+		id := int(by.Int64)
+		s.By = &id
+	}
+	return s, nil
+}
+
+// Suspensión para la auditoría: sin el motivo, que es texto libre y puede
+// contener datos personales
+
+func suspensionAudit(s *Suspension) interface{} {
+	if s == nil {
+		return nil
+	}
+	return map[string]interface{}{"since": s.Since, "until": s.Until, "by": s.By}
+}
+
+// Responder 401/403 si la cuenta no puede usarse; devuelve true si respondió
+
+func rejectInactiveAccount(w http.ResponseWriter, r *http.Request, userID int) bool {
+	st, err := loadAccountState(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error al consultar el estado de la cuenta", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return true
+	}
+	if st.Deleted {
+		writeError(w, r, http.StatusUnauthorized, "ACCOUNT_DELETED")
+		return true
+	}
+	if s := st.Suspension; s != nil {
+		details := map[string]interface{}{"reason": s.Reason, "until": s.Until}
+		if s.Until != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(*s.Until)))
+		}
+		writeErrorDetails(w, r, http.StatusForbidden, "ACCOUNT_SUSPENDED", details)
+		return true
+	}
+	return false
+}
+
+// Indica si userID es el único admin activo (ni eliminado ni suspendido).
+// Bloquea las filas de todos los admins hasta el commit de tx para que dos
+// bajas simultáneas no dejen el sistema sin ninguno. Devuelve sql.ErrNoRows si el usuario no existe.
+
+func isLastActiveAdminTx(tx *sql.Tx, userID int) (bool, error) {
+	var isAdmin bool
+	err := tx.QueryRow(`SELECT role = 'admin' FROM users WHERE id = $1`, userID).Scan(&isAdmin)
+	if err != nil || !isAdmin {
+		return false, err
+	}
+	rows, err := tx.Query(`
+		SELECT id, deleted_at IS NULL AND NOT (` + suspensionActiveSQL + `)
+		FROM users WHERE role = 'admin'
+		ORDER BY id
+		FOR UPDATE`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	others := 0
+	for rows.Next() {
+		var id int
+		var active bool
+		if err := rows.Scan(&id, &active); err != nil {
+			return false, err
+		}
+		if id != userID && active {
+			others++
+		}
+	}
+	return others == 0, rows.Err()
+}
+
+var errLastAdmin = errors.New("es el último admin activo")
+
+// ID del usuario de la ruta y comprobación de que no es el propio admin
+
+func targetUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
+		return 0, false
+	}
+	if adminID, err := userIDFromRequest(r); err == nil && adminID == id {
+		writeError(w, r, http.StatusConflict, "SUSPEND_SELF")
+		return 0, false
+	}
+	return id, true
+}
+
+// Roles que se pueden asignar a un usuario
+
+func validRole(role string) bool {
+	return role == "user" || role == "admin"
+}
+
+// Suspender (o banear sin "until") a un usuario
+
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+	adminID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "SUSPENSION_REASON_REQUIRED", "reason")
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		writeFieldError(w, r, http.StatusBadRequest, "SUSPENSION_UNTIL_INVALID", "until")
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	last, err := isLastActiveAdminTx(tx, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if last {
+		writeError(w, r, http.StatusConflict, "LAST_ADMIN")
+		return
+	}
+	prev, err := loadSuspensionTx(tx, id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+
+	s := Suspension{Reason: req.Reason, Until: req.Until, By: &adminID}
+	err = tx.QueryRow(`
+		UPDATE users SET suspended_at = CURRENT_TIMESTAMP, suspended_until = $2, suspension_reason = $3, suspended_by = $4
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING suspended_at`, id, req.Until, s.Reason, adminID).Scan(&s.Since)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error al suspender usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	if err := recordAudit(tx, r, auditUserSuspend, auditTargetUser, id, suspensionAudit(prev), suspensionAudit(&s)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	requestLogger(r).Info("Usuario suspendido", "target_user_id", id, "until", req.Until)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+// Levantar la suspensión de un usuario
+
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	prev, err := loadSuspensionTx(tx, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if _, err := tx.Exec(`
+		UPDATE users SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL
+		WHERE id = $1`, id); err != nil {
+		requestLogger(r).Error("Error al levantar suspensión", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	if prev != nil {
+		if err := recordAudit(tx, r, auditUserUnsuspend, auditTargetUser, id, suspensionAudit(prev), nil); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_SUSPEND_FAILED")
+		return
+	}
+	requestLogger(r).Info("Suspensión levantada", "target_user_id", id)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Suspensión levantada"})
+}
+
+// Restaurar un usuario eliminado
+
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_RESTORE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	// Una cuenta anonimizada ya no tiene email ni credenciales que restaurar
+	var erased bool
+	var deletedAt sql.NullTime
+	err = tx.QueryRow(`SELECT erased_at IS NOT NULL, deleted_at FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&erased, &deletedAt)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if erased {
+		writeError(w, r, http.StatusConflict, "USER_ERASED")
+		return
+	}
+	if _, err := tx.Exec(`UPDATE users SET deleted_at = NULL WHERE id = $1`, id); err != nil {
+		requestLogger(r).Error("Error al restaurar usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_RESTORE_FAILED")
+		return
+	}
+	if deletedAt.Valid {
+		if err := recordAudit(tx, r, auditUserRestore, auditTargetUser, id, map[string]interface{}{"deletedAt": deletedAt.Time}, nil); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_RESTORE_FAILED")
+		return
+	}
+	requestLogger(r).Info("Usuario restaurado", "target_user_id", id)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Usuario restaurado"})
+}
+
+// Borrar definitivamente un usuario eliminado junto con su historial. Los
+// intentos se borran a mano porque en algunas bases sus claves foráneas no
+// tienen ON DELETE; el resto de tablas se borran en cascada.
+
+func PurgeUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_PURGE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRow(`SELECT deleted_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_PURGE_FAILED")
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusConflict, "USER_NOT_DELETED")
+		return
+	}
+
+	for _, q := range []string{
+		`DELETE FROM attempt_summary WHERE user_id = $1`,
+		`DELETE FROM attempts WHERE user_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			requestLogger(r).Error("Error al purgar usuario", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "USER_PURGE_FAILED")
+			return
+		}
+	}
+	// Sin datos del usuario: la purga es para que no quede ninguno
+	if err := recordAudit(tx, r, auditUserPurge, auditTargetUser, id, nil, nil); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_PURGE_FAILED")
+		return
+	}
+	requestLogger(r).Info("Usuario purgado", "target_user_id", id)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Usuario purgado"})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Parámetros del rating tipo Elo usado en el modo adaptativo
+const (
+	initialSkillRating = 1200.0
+	userRatingK        = 32.0
+	questionRatingK    = 16.0
+)
+
+// Rating inicial de una pregunta según su dificultad, de menor a mayor (en
+// caso de empate difficultyForRating elige la primera)
+var difficultyRatings = []struct {
+	name   string
+	rating float64
+}{
+	{"fácil", 900},
+	{"media", 1200},
+	{"difícil", 1500},
+}
+
+// Expresión SQL del rating efectivo de una pregunta (rating propio o el de su dificultad)
+const questionRatingSQL = `COALESCE(q.rating, CASE q.dificultad
+	WHEN 'fácil' THEN 900 WHEN 'media' THEN 1200 WHEN 'difícil' THEN 1500 ELSE 1200 END)`
+
+// Probabilidad esperada de acierto de un usuario frente a una pregunta
+
+func expectedScore(userRating, questionRating float64) float64 {
+	return 1 / (1 + math.Pow(10, (questionRating-userRating)/400))
+}
+
+// Dificultad nominal más cercana a un rating
+
+func difficultyForRating(rating float64) string {
+	best, bestDiff := "media", math.Inf(1)
+	for _, dr := range difficultyRatings {
+		if d := math.Abs(rating - dr.rating); d < bestDiff {
+			best, bestDiff = dr.name, d
+		}
+	}
+	return best
+}
+
+// Nuevos ratings de usuario y pregunta tras una respuesta: el usuario gana lo
+// que la pregunta pierde, escalado por su factor K
+
+func eloUpdate(userRating, questionRating float64, isCorrect bool) (float64, float64) {
+	score := 0.0
+	if isCorrect {
+		score = 1
+	}
+	delta := score - expectedScore(userRating, questionRating)
+	return userRating + userRatingK*delta, questionRating - questionRatingK*delta
+}
+
+func userSkillRating(userID int) (float64, error) {
+	var rating float64
+	err := DB.QueryRow(`SELECT rating FROM user_skill WHERE user_id = $1`, userID).Scan(&rating)
+	if err == sql.ErrNoRows {
+		return initialSkillRating, nil
+	}
+	return rating, err
+}
+
+// Siguiente pregunta del modo adaptativo: la no respondida recientemente cuyo
+// rating está más cerca del rating actual del usuario
+
+func GetAdaptiveQuestion(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	rating, err := userSkillRating(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "RATING_FETCH_FAILED")
+		return
+	}
+
+	query := `
+		SELECT q.id, q.question, q.correct_answer, q.incorrect_answers, COALESCE(q.type, 'multiple'),
+		       COALESCE(q.correct_answers, '{}'), ` + questionRatingSQL + `
+		FROM questions q
+		WHERE NOT EXISTS (
+			SELECT 1 FROM attempts a
+			WHERE a.user_id = $1 AND a.question_id = q.id
+			  AND (a.is_correct OR a.answered_at > CURRENT_TIMESTAMP - INTERVAL '1 day')
+		)`
+	args := []interface{}{userID, rating}
+	if categoria := r.URL.Query().Get("categoria"); categoria != "" {
+		query += " AND q.categoria = $3"
+		args = append(args, categoria)
+	}
+	query += " ORDER BY ABS(" + questionRatingSQL + " - $2), random() LIMIT 1"
+
+	var q Question
+	var qRating float64
+	err = DB.QueryRow(query, args...).
+		Scan(&q.ID, &q.Question, &q.CorrectAnswer, pq.Array(&q.IncorrectAnswers), &q.Type,
+			pq.Array(&q.CorrectAnswers), &qRating)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "NO_QUESTIONS_AVAILABLE")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error al seleccionar pregunta adaptativa", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+
+	// Solo esta pregunta podrá responderse en /quiz/adaptive/answer
+	if _, err := DB.Exec(`
+		INSERT INTO user_skill (user_id, pending_question_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET pending_question_id = $2`, userID, q.ID); err != nil {
+		requestLogger(r).Error("Error al registrar pregunta adaptativa pendiente", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+
+	translated := []Question{q}
+	if err := applyTranslations(translated, requestLang(r)); err != nil {
+		requestLogger(r).Warn("No se pudieron aplicar traducciones", "error", err)
+	}
+	q = translated[0]
+
+	// La respuesta correcta se valida en el servidor; no se envía al cliente
+	options := shuffledOptions(q, rand.New(rand.NewSource(time.Now().UnixNano())))
+	item := AdaptiveQuestion{
+		ID:             q.ID,
+		Question:       q.Question,
+		Type:           q.Type,
+		Options:        options,
+		Difficulty:     difficultyForRating(qRating),
+		QuestionRating: qRating,
+		UserRating:     rating,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(item)
+}
+
+// Responder la pregunta adaptativa pendiente y actualizar los ratings de
+// usuario y pregunta. Ambas filas se bloquean (primero la del usuario) para que
+// respuestas concurrentes no pisen los ratings.
+
+func AnswerAdaptiveQuestion(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	var a AttemptAnswer
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "RATING_UPDATE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+
+	var uRating float64
+	var pending sql.NullInt64
+	err = tx.QueryRow(`SELECT rating, pending_question_id FROM user_skill WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&uRating, &pending)
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, r, http.StatusInternalServerError, "RATING_FETCH_FAILED")
+		return
+	}
+	if !pending.Valid || pending.Int64 != int64(a.QuestionID) {
+		writeError(w, r, http.StatusConflict, "ADAPTIVE_NOT_PENDING")
+		return
+	}
+
+	var qRating float64
+	err = tx.QueryRow(`SELECT `+questionRatingSQL+` FROM questions q WHERE q.id = $1 FOR UPDATE`, a.QuestionID).Scan(&qRating)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "QUESTION_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+
+	key, err := loadQuestionKey(a.QuestionID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+	isCorrect := gradeAnswer(key, a.SelectedAnswer, a.SelectedAnswers)
+	if key.Type == QuestionMultiSelect && len(a.SelectedAnswers) > 0 {
+		a.SelectedAnswer = joinMultiAnswer(a.SelectedAnswers)
+	}
+	newUserRating, newQuestionRating := eloUpdate(uRating, qRating, isCorrect)
+
+	if _, err := tx.Exec(`
+		INSERT INTO attempts (user_id, question_id, selected_answer, is_correct)
+		VALUES ($1, $2, $3, $4)`,
+		userID, a.QuestionID, a.SelectedAnswer, isCorrect); err != nil {
+		requestLogger(r).Error("Error al guardar intento adaptativo", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
+		return
+	}
+	if _, err := tx.Exec(`
+		UPDATE user_skill
+		SET rating = $2, answered = answered + 1, pending_question_id = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1`,
+		userID, newUserRating); err != nil {
+		requestLogger(r).Error("Error al actualizar rating de usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "RATING_UPDATE_FAILED")
+		return
+	}
+	if _, err := tx.Exec(`UPDATE questions SET rating = $2 WHERE id = $1`, a.QuestionID, newQuestionRating); err != nil {
+		requestLogger(r).Error("Error al actualizar rating de pregunta", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "RATING_UPDATE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "RATING_UPDATE_FAILED")
+		return
+	}
+	recordAnswer("adaptive", isCorrect)
+	if !isCorrect {
+		if err := scheduleMissedQuestion(userID, a.QuestionID); err != nil {
+			requestLogger(r).Warn("No se pudo programar repaso", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"questionId":     a.QuestionID,
+		"isCorrect":      isCorrect,
+		"correctAnswer":  key.CorrectAnswer,
+		"previousRating": uRating,
+		"userRating":     newUserRating,
+		"nextDifficulty": difficultyForRating(newUserRating),
+	})
+}
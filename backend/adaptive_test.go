@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpectedScore(t *testing.T) {
+	tests := []struct {
+		user, question, want float64
+	}{
+		{1200, 1200, 0.5},
+		{1600, 1200, 1 / (1 + math.Pow(10, -1))},
+		{1200, 1600, 1 / (1 + math.Pow(10, 1))},
+	}
+	for _, tt := range tests {
+		if got := expectedScore(tt.user, tt.question); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("expectedScore(%v, %v) = %v, want %v", tt.user, tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestEloUpdate(t *testing.T) {
+	tests := []struct {
+		name                   string
+		user, question         float64
+		isCorrect              bool
+		wantUser, wantQuestion float64
+	}{
+		{"acierto entre iguales", 1200, 1200, true, 1216, 1192},
+		{"fallo entre iguales", 1200, 1200, false, 1184, 1208},
+		{"acierto esperado apenas sube", 1600, 1200, true, 1600 + 32*(1-1/1.1), 1200 - 16*(1-1/1.1)},
+		{"fallo inesperado baja mucho", 1600, 1200, false, 1600 - 32/1.1, 1200 + 16/1.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser, gotQuestion := eloUpdate(tt.user, tt.question, tt.isCorrect)
+			if math.Abs(gotUser-tt.wantUser) > 1e-9 || math.Abs(gotQuestion-tt.wantQuestion) > 1e-9 {
+				t.Errorf("eloUpdate = (%v, %v), want (%v, %v)", gotUser, gotQuestion, tt.wantUser, tt.wantQuestion)
+			}
+		})
+	}
+}
+
+func TestDifficultyForRating(t *testing.T) {
+	tests := []struct {
+		rating float64
+		want   string
+	}{
+		{800, "fácil"},
+		{1100, "media"},
+		{1300, "media"},
+		{1400, "difícil"},
+		{1050, "fácil"}, // empate: gana la dificultad menor
+		{1350, "media"},
+		{2000, "difícil"},
+	}
+	for _, tt := range tests {
+		if got := difficultyForRating(tt.rating); got != tt.want {
+			t.Errorf("difficultyForRating(%v) = %s, want %s", tt.rating, got, tt.want)
+		}
+	}
+}
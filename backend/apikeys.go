@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Claves de API para scripts e integraciones (LMS). Las crea un admin, se
+// envían como "Authorization: Bearer qf_..." y solo valen en las rutas que
+// declaran alguno de sus scopes en AuthMiddleware. En la base de datos solo se
+// guarda el hash SHA-256; la clave completa se muestra una vez al crearla.
+
+const apiKeyPrefix = "qf_"
+
+// Permisos que se pueden conceder a una clave
+const (
+	scopeQuestionsRead  = "questions:read"
+	scopeQuestionsWrite = "questions:write"
+	scopeResultsRead    = "results:read"
+	scopeUsersRead      = "users:read"
+)
+
+var apiKeyScopes = []string{scopeQuestionsRead, scopeQuestionsWrite, scopeResultsRead, scopeUsersRead}
+
+// last_used_at se actualiza como mucho una vez por minuto por clave
+const apiKeyTouchInterval = time.Minute
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Autenticar una clave: devuelve su ID y scopes si existe, no está revocada ni caducada
+
+func authenticateAPIKey(r *http.Request, key string) (int, []string, error) {
+	var id int
+	var scopes []string
+	err := DB.QueryRowContext(r.Context(), `
+		SELECT id, scopes FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`, hashAPIKey(key)).
+		Scan(&id, pq.Array(&scopes))
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := DB.ExecContext(r.Context(), `
+		UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`,
+		id, time.Now().Add(-apiKeyTouchInterval)); err != nil {
+		requestLogger(r).Warn("No se pudo actualizar el último uso de la clave de API", "error", err)
+	}
+	return id, scopes, nil
+}
+
+func hasAnyScope(granted, required []string) bool {
+	for _, s := range required {
+		if containsString(granted, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listar las claves (sin el secreto)
+
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := DB.Query(`
+		SELECT id, name, prefix, scopes, created_by, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys ORDER BY id`)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEYS_FETCH_FAILED")
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var createdBy sql.NullInt64
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &createdBy, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "API_KEYS_FETCH_FAILED")
+			return
+		}
+		if createdBy.Valid {
+			id := int(createdBy.Int64)
+			k.CreatedBy = &id
+		}
+		k.ExpiresAt, k.LastUsedAt, k.RevokedAt = nullTimePtr(expiresAt), nullTimePtr(lastUsedAt), nullTimePtr(revokedAt)
+		keys = append(keys, k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// Crear una clave; la respuesta incluye la clave completa por única vez
+
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "API_KEY_NAME_REQUIRED", "name")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeFieldError(w, r, http.StatusBadRequest, "API_KEY_SCOPE_INVALID", "scopes")
+		return
+	}
+	for _, s := range req.Scopes {
+		if !containsString(apiKeyScopes, s) {
+			writeFieldError(w, r, http.StatusBadRequest, "API_KEY_SCOPE_INVALID", "scopes")
+			return
+		}
+	}
+
+	// Sin expiresAt caduca tras API_KEY_DEFAULT_TTL (0: no caduca)
+	expiresAt := req.ExpiresAt
+	if expiresAt == nil && AppConfig.APIKeyDefaultTTL > 0 {
+		t := time.Now().Add(AppConfig.APIKeyDefaultTTL)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		writeFieldError(w, r, http.StatusBadRequest, "API_KEY_EXPIRY_INVALID", "expiresAt")
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_CREATE_FAILED")
+		return
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	k := APIKey{Name: req.Name, Prefix: secret[:len(apiKeyPrefix)+6], Scopes: req.Scopes, CreatedBy: &adminID, ExpiresAt: expiresAt}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_CREATE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`, k.Name, k.Prefix, hashAPIKey(secret), pq.Array(k.Scopes), adminID, expiresAt).
+		Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		requestLogger(r).Error("Error al crear clave de API", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_CREATE_FAILED")
+		return
+	}
+
+	// El nombre es texto libre y no se audita
+	if err := recordAudit(tx, r, auditAPIKeyCreate, auditTargetAPIKey, k.ID, nil,
+		map[string]interface{}{"prefix": k.Prefix, "scopes": k.Scopes, "expiresAt": k.ExpiresAt}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_CREATE_FAILED")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		APIKey
+		Key string `json:"key"`
+	}{k, secret})
+}
+
+// Revocar una clave (se conserva para consultar su historial)
+
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "API_KEY_ID_INVALID")
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_REVOKE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1`, id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_REVOKE_FAILED")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, "API_KEY_NOT_FOUND")
+		return
+	}
+	if err := recordAudit(tx, r, auditAPIKeyRevoke, auditTargetAPIKey, id, nil, nil); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "API_KEY_REVOKE_FAILED")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Clave de API revocada"})
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestHashAPIKey(t *testing.T) {
+	// SHA-256 de "abc" (FIPS 180-2)
+	if got, want := hashAPIKey("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("hashAPIKey(abc) = %s, want %s", got, want)
+	}
+	key := apiKeyPrefix + "clave-de-prueba"
+	if hashAPIKey(key) != hashAPIKey(key) {
+		t.Error("hashAPIKey no es determinista")
+	}
+	if hashAPIKey(key) == hashAPIKey(key+"x") {
+		t.Error("hashAPIKey devolvió el mismo hash para claves distintas")
+	}
+	if h := hashAPIKey(key); len(h) != 64 {
+		t.Errorf("len(hashAPIKey) = %d, want 64", len(h))
+	}
+}
+
+func TestIsAPIKey(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"qf_abc123", true},
+		{"eyJhbGciOiJFZERTQSJ9.e30.sig", false},
+		{"QF_abc123", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isAPIKey(tt.token); got != tt.want {
+			t.Errorf("isAPIKey(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestHasAnyScope(t *testing.T) {
+	granted := []string{scopeQuestionsRead, scopeResultsRead}
+	if !hasAnyScope(granted, []string{scopeQuestionsWrite, scopeQuestionsRead}) {
+		t.Error("hasAnyScope rechazó un scope concedido")
+	}
+	if hasAnyScope(granted, []string{scopeUsersRead}) {
+		t.Error("hasAnyScope aceptó un scope no concedido")
+	}
+	if hasAnyScope(granted, nil) {
+		t.Error("hasAnyScope aceptó una ruta sin scopes")
+	}
+}
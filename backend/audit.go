@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Registro de auditoría de las acciones administrativas. Cada handler que
+// modifica datos llama a recordAudit dentro de la misma transacción que el
+// cambio: si la entrada no se puede escribir el cambio no se aplica. El actor
+// (usuario o clave de API), la IP y el ID de la solicitud se toman del
+// contexto que rellenan los middlewares. La tabla es de solo inserción: un
+// trigger rechaza UPDATE, DELETE y TRUNCATE.
+
+// Acciones registradas
+const (
+	auditUserCreate        = "user.create"
+	auditUserRoleUpdate    = "user.role_update"
+	auditUserDelete        = "user.delete"
+	auditUserRestore       = "user.restore"
+	auditUserSuspend       = "user.suspend"
+	auditUserUnsuspend     = "user.unsuspend"
+	auditUserPurge         = "user.purge"
+	auditUserErase         = "user.erase"
+	auditAPIKeyCreate      = "api_key.create"
+	auditAPIKeyRevoke      = "api_key.revoke"
+	auditQuestionCreate    = "question.create"
+	auditQuestionsImport   = "question.import"
+	auditTranslationUpsert = "question_translation.upsert"
+	auditTranslationDelete = "question_translation.delete"
+)
+
+// Tipos de objetivo
+const (
+	auditTargetUser        = "user"
+	auditTargetAPIKey      = "api_key"
+	auditTargetQuestion    = "question"
+	auditTargetTranslation = "question_translation"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// Error de las funciones que registran la auditoría por su cuenta
+var errAuditWrite = errors.New("audit write failed")
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	ActorID    *int            `json:"actorId"`
+	ActorKeyID *int            `json:"actorKeyId"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   string          `json:"targetId"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"requestId"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// Registrar una acción en la transacción tx. before y after son el estado
+// anterior y el nuevo (nil si no aplica) y solo pueden incluir IDs y campos
+// no personales: nunca emails, nombres, textos libres como el motivo de una
+// suspensión, contraseñas ni secretos. El registro se conserva aunque el
+// usuario se borre o anonimice.
+
+func recordAudit(tx *sql.Tx, r *http.Request, action, targetType string, targetID interface{}, before, after interface{}) error {
+	var actorID, keyID, requestID interface{}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		if info.UserID != 0 {
+			actorID = info.UserID
+		}
+		if info.KeyID != 0 {
+			keyID = info.KeyID
+		}
+		requestID = info.ID
+	}
+	target := ""
+	if targetID != nil {
+		target = fmt.Sprint(targetID)
+	}
+	beforeJSON, err := auditJSON(before)
+	var afterJSON interface{}
+	if err == nil {
+		afterJSON, err = auditJSON(after)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(r.Context(), `
+			INSERT INTO audit_log (actor_id, actor_key_id, action, target_type, target_id, before, after, ip, request_id)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9)`,
+			actorID, keyID, action, targetType, target, beforeJSON, afterJSON, clientIP(r), requestID)
+	}
+	if err != nil {
+		requestLogger(r).Error("No se pudo registrar la auditoría", "action", action, "target_id", target, "error", err)
+	}
+	return err
+}
+
+// Valor para una columna JSONB (NULL si v es nil)
+
+func auditJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Consultar el registro (más reciente primero). Filtros opcionales: actorId,
+// action (exacta, o prefijo terminado en "." como "user."), targetType,
+// targetId, requestId, from y to (RFC 3339). Paginación con limit y beforeId
+// (el id de la última entrada recibida).
+
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{}
+	args := []interface{}{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	for _, p := range []struct{ param, cond string }{
+		{"actorId", "actor_id = $%d"},
+		{"beforeId", "id < $%d"},
+	} {
+		if v := q.Get(p.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", p.param)
+				return
+			}
+			add(p.cond, n)
+		}
+	}
+	if v := q.Get("action"); strings.HasSuffix(v, ".") {
+		add("action LIKE $%d", v+"%")
+	} else if v != "" {
+		add("action = $%d", v)
+	}
+	for _, p := range []struct{ param, cond string }{
+		{"targetType", "target_type = $%d"},
+		{"targetId", "target_id = $%d"},
+		{"requestId", "request_id = $%d"},
+	} {
+		if v := q.Get(p.param); v != "" {
+			add(p.cond, v)
+		}
+	}
+	for _, p := range []struct{ param, cond string }{
+		{"from", "created_at >= $%d"},
+		{"to", "created_at < $%d"},
+	} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", p.param)
+				return
+			}
+			add(p.cond, t.UTC())
+		}
+	}
+
+	limit := defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "limit")
+			return
+		}
+		limit = n
+		if limit > maxAuditLimit {
+			limit = maxAuditLimit
+		}
+	}
+
+	query := `SELECT id, actor_id, actor_key_id, action, target_type, COALESCE(target_id, ''), before, after,
+		COALESCE(ip, ''), COALESCE(request_id, ''), created_at FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := DB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		requestLogger(r).Error("Error al consultar la auditoría", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_FETCH_FAILED")
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var actorID, keyID sql.NullInt64
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &actorID, &keyID, &e.Action, &e.TargetType, &e.TargetID, &before, &after,
+			&e.IP, &e.RequestID, &e.CreatedAt); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "AUDIT_FETCH_FAILED")
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			e.ActorID = &id
+		}
+		if keyID.Valid {
+			id := int(keyID.Int64)
+			e.ActorKeyID = &id
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditJSON(t *testing.T) {
+	if v, err := auditJSON(nil); v != nil || err != nil {
+		t.Errorf("auditJSON(nil) = %v, %v, want nil, nil", v, err)
+	}
+	v, err := auditJSON(map[string]string{"role": "admin"})
+	if err != nil || v != `{"role":"admin"}` {
+		t.Errorf("auditJSON = %v, %v", v, err)
+	}
+	if _, err := auditJSON(func() {}); err == nil {
+		t.Error("auditJSON(func) sin error")
+	}
+}
+
+func TestSuspensionAuditOmitsReason(t *testing.T) {
+	if v := suspensionAudit(nil); v != nil {
+		t.Errorf("suspensionAudit(nil) = %v, want nil", v)
+	}
+	by := 1
+	s := &Suspension{Reason: "insultos a Ana García", Since: time.Now(), By: &by}
+	v, err := auditJSON(suspensionAudit(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := v.(string)
+	if strings.Contains(got, "reason") || strings.Contains(got, "Ana") {
+		t.Errorf("la auditoría incluye el motivo: %s", got)
+	}
+	for _, k := range []string{`"since"`, `"until"`, `"by"`} {
+		if !strings.Contains(got, k) {
+			t.Errorf("falta %s en %s", k, got)
+		}
+	}
+}
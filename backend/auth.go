@@ -1,74 +1,146 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey []byte
+// Generar token con email, rol y userID; mfa indica si se completó el segundo factor
 
-func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "2435"
-	}
-	jwtKey = []byte(secret)
-}
-
-// Generar token con email, rol y userID
-
-func GenerateToken(email string, role string, userID int) (string, error) {
+func GenerateToken(email string, role string, userID int, mfa bool) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
+		"iss":   AppConfig.JWTIssuer,
+		"aud":   AppConfig.JWTAudience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(AppConfig.TokenTTL).Unix(),
 		"email": email,
 		"role":  role,
 		"user":  userID,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+		"mfa":   mfa,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	key := jwtKeys.Load().signing
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Signing)
 }
 
-// Verificar token y extraer claims
+// Verificar token y extraer claims. El algoritmo lo fija la clave del kid, no
+// la cabecera del token, así que no se acepta "none" ni HS256 firmado con una
+// clave pública. Solo se admiten los algoritmos de las claves cargadas: con
+// JWT_KEYS_DIR nunca HS256.
 
 func VerifyToken(tokenStr string) (jwt.MapClaims, error) {
+	set := jwtKeys.Load()
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := set.lookup(kid)
+		if !ok {
+			return nil, errors.New("kid desconocido")
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("algoritmo no permitido")
+		}
+		return key.Verifying, nil
+	},
+		jwt.WithValidMethods(set.algorithms()),
+		jwt.WithIssuer(AppConfig.JWTIssuer),
+		jwt.WithAudience(AppConfig.JWTAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
 		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, err
+	if !ok || !token.Valid {
+		return nil, errors.New("token inválido")
 	}
 	return claims, nil
 }
 
-// Middleware para proteger rutas por rol
+// Extraer el userID del token Bearer de la solicitud
+
+func userIDFromRequest(r *http.Request) (int, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return 0, errors.New("token faltante")
+	}
+	claims, err := VerifyToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil || claims == nil {
+		return 0, errors.New("token inválido")
+	}
+	userID, ok := claims["user"].(float64)
+	if !ok {
+		return 0, errors.New("ID de usuario no válido")
+	}
+	return int(userID), nil
+}
+
+// Middleware para proteger rutas por rol. Las claves de API solo se aceptan
+// en las rutas que indican scopes y si la clave tiene alguno de ellos.
 
-func AuthMiddleware(next http.HandlerFunc, requiredRole string) http.HandlerFunc {
+func AuthMiddleware(next http.HandlerFunc, requiredRole string, scopes ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Token faltante", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "TOKEN_MISSING")
 			return
 		}
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 
+		if isAPIKey(tokenStr) {
+			keyID, granted, err := authenticateAPIKey(r, tokenStr)
+			if err != nil {
+				if err != sql.ErrNoRows {
+					requestLogger(r).Error("Error al comprobar clave de API", "error", err)
+				}
+				writeError(w, r, http.StatusUnauthorized, "API_KEY_INVALID")
+				return
+			}
+			if info := requestInfoFrom(r.Context()); info != nil {
+				info.KeyID = keyID
+			}
+			if !hasAnyScope(granted, scopes) {
+				writeError(w, r, http.StatusForbidden, "API_KEY_SCOPE_MISSING")
+				return
+			}
+			next(w, r)
+			return
+		}
+
 		claims, err := VerifyToken(tokenStr)
 		if err != nil {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+			return
+		}
+		userID, ok := claims["user"].(float64)
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+			return
+		}
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.UserID = int(userID)
+		}
+		// El JWT sigue siendo válido tras eliminar o suspender la cuenta
+		if rejectInactiveAccount(w, r, int(userID)) {
 			return
 		}
 
 		if requiredRole != "" {
 			role, ok := claims["role"].(string)
 			if !ok || role != requiredRole {
-				http.Error(w, "No autorizado", http.StatusForbidden)
+				writeError(w, r, http.StatusForbidden, "FORBIDDEN")
+				return
+			}
+			// Los roles con 2FA obligatorio necesitan una sesión iniciada con segundo factor
+			if mfa, _ := claims["mfa"].(bool); !mfa && roleRequiresTOTP(role) {
+				writeError(w, r, http.StatusForbidden, "TOTP_REQUIRED")
 				return
 			}
 		}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Sustituir el juego de claves activo durante un test
+func useJWTKeys(t *testing.T, set *jwtKeySet) {
+	t.Helper()
+	prev := jwtKeys.Load()
+	jwtKeys.Store(set)
+	t.Cleanup(func() { jwtKeys.Store(prev) })
+}
+
+func testClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":  AppConfig.JWTIssuer,
+		"aud":  AppConfig.JWTAudience,
+		"iat":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+		"user": 5,
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerifyTokenAsymmetricKeys(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPub, oldPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := &jwtKey{ID: "2026-10", Method: jwt.SigningMethodEdDSA, Signing: edPriv, Verifying: edPub}
+	set := &jwtKeySet{signing: current, keys: map[string]*jwtKey{
+		current.ID: current,
+		"2026-09":  {ID: "2026-09", Method: jwt.SigningMethodEdDSA, Verifying: oldPub},
+		"rsa":      {ID: "rsa", Method: jwt.SigningMethodRS256, Verifying: &rsaPriv.PublicKey},
+	}}
+	useJWTKeys(t, set)
+
+	if got, want := set.algorithms(), []string{"EdDSA", "RS256"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("algorithms() = %v, want %v", got, want)
+	}
+
+	expired := testClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	otherAudience := testClaims()
+	otherAudience["aud"] = "otro-servicio"
+	noneToken := signTestToken(t, jwt.SigningMethodNone, current.ID, jwt.UnsafeAllowNoneSignatureType, testClaims())
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"clave de firma", signTestToken(t, jwt.SigningMethodEdDSA, current.ID, edPriv, testClaims()), true},
+		{"clave anterior solo de verificación", signTestToken(t, jwt.SigningMethodEdDSA, "2026-09", oldPriv, testClaims()), true},
+		{"RSA con su kid", signTestToken(t, jwt.SigningMethodRS256, "rsa", rsaPriv, testClaims()), true},
+		{"kid desconocido", signTestToken(t, jwt.SigningMethodEdDSA, "2025-01", edPriv, testClaims()), false},
+		{"sin kid", signTestToken(t, jwt.SigningMethodEdDSA, "", edPriv, testClaims()), false},
+		{"algoritmo distinto al de la clave", signTestToken(t, jwt.SigningMethodEdDSA, "rsa", edPriv, testClaims()), false},
+		{"HS256 con la clave pública", signTestToken(t, jwt.SigningMethodHS256, current.ID, []byte(edPub), testClaims()), false},
+		{"alg none", noneToken, false},
+		{"firma de otra clave", signTestToken(t, jwt.SigningMethodEdDSA, current.ID, oldPriv, testClaims()), false},
+		{"caducado", signTestToken(t, jwt.SigningMethodEdDSA, current.ID, edPriv, expired), false},
+		{"otra audiencia", signTestToken(t, jwt.SigningMethodEdDSA, current.ID, edPriv, otherAudience), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyToken(tt.token)
+			if (err == nil) != tt.wantOK {
+				t.Fatalf("VerifyToken err = %v, wantOK %v", err, tt.wantOK)
+			}
+			if tt.wantOK && claims["user"] != float64(5) {
+				t.Errorf("claims[user] = %v, want 5", claims["user"])
+			}
+		})
+	}
+}
+
+func TestVerifyTokenHS256(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.JWTSecret = "secreto-de-pruebas-de-al-menos-32-caracteres"
+	set, err := loadJWTKeys(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJWTKeys(t, set)
+
+	if got := set.algorithms(); !reflect.DeepEqual(got, []string{"HS256"}) {
+		t.Errorf("algorithms() = %v, want [HS256]", got)
+	}
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := signTestToken(t, jwt.SigningMethodHS256, "hs256", []byte(cfg.JWTSecret), testClaims())
+	if _, err := VerifyToken(valid); err != nil {
+		t.Errorf("VerifyToken(HS256 válido) err = %v", err)
+	}
+	wrongSecret := signTestToken(t, jwt.SigningMethodHS256, "hs256", []byte("otro-secreto"), testClaims())
+	if _, err := VerifyToken(wrongSecret); err == nil {
+		t.Error("VerifyToken aceptó un HS256 firmado con otro secreto")
+	}
+	eddsa := signTestToken(t, jwt.SigningMethodEdDSA, "hs256", edPriv, testClaims())
+	if _, err := VerifyToken(eddsa); err == nil {
+		t.Error("VerifyToken aceptó EdDSA sin claves asimétricas cargadas")
+	}
+}
+
+func TestValidateRequiresJWTKeysDirInProduction(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Env = "production"
+	cfg.JWTSecret = "secreto-de-pruebas-de-al-menos-32-caracteres"
+	cfg.DatabaseURL = "postgres://app:s3cr3t-largo@db/quizforge_db"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() aceptó producción sin JWT_KEYS_DIR")
+	}
+	cfg.JWTKeysDir = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
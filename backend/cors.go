@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Política CORS. Los orígenes admiten coincidencia exacta
+// ("https://quiz.example.com"), comodín de subdominio ("https://*.example.com")
+// o "*" para cualquier origen (nunca con credenciales).
+
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// Política por ruta: exact compara la ruta completa; si no, se usa como
+// prefijo por segmentos y gana el prefijo más largo que coincida
+
+type corsRoute struct {
+	path   string
+	exact  bool
+	policy *CORSPolicy
+}
+
+func (c corsRoute) matches(path string) bool {
+	if c.exact {
+		return path == c.path
+	}
+	return path == c.path || strings.HasPrefix(path, c.path+"/")
+}
+
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if matchOrigin(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Coincidencia de origen; el comodín exige al menos un subdominio y mismo esquema y puerto
+
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	prefix := scheme + "://"
+	if !strings.HasPrefix(origin, prefix) {
+		return false
+	}
+	rest := strings.TrimPrefix(origin, prefix)
+	return strings.HasSuffix(rest, "."+host) && len(rest) > len(host)+1 && !strings.Contains(rest[:len(rest)-len(host)-1], "/")
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware CORS con políticas por ruta. Las solicitudes de orígenes no
+// permitidos no reciben cabeceras CORS y sus preflights se rechazan con 403.
+
+func corsMiddleware(defaultPolicy *CORSPolicy, routes []corsRoute) mux.MiddlewareFunc {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := defaultPolicy
+			matched := 0
+			for _, route := range routes {
+				if route.matches(r.URL.Path) && len(route.path) > matched {
+					policy, matched = route.policy, len(route.path)
+				}
+			}
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+
+			if origin == "" {
+				// No es una solicitud CORS
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !policy.allowsOrigin(origin) {
+				if preflight {
+					writeError(w, r, http.StatusForbidden, "CORS_ORIGIN_FORBIDDEN")
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			// Con credenciales nunca se responde "*": se refleja el origen validado
+			if policy.allowsAnyOrigin() && !policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !containsFold(policy.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+				writeError(w, r, http.StatusForbidden, "CORS_METHOD_FORBIDDEN")
+				return
+			}
+			for _, header := range splitList(r.Header.Get("Access-Control-Request-Headers")) {
+				if !containsFold(policy.AllowedHeaders, header) {
+					writeError(w, r, http.StatusForbidden, "CORS_HEADER_FORBIDDEN")
+					return
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// Políticas a partir de la configuración: la general para la API, una más
+// estricta para /admin y, opcionalmente, una abierta sin credenciales para GET /questions
+
+func corsPolicies(cfg Config) (*CORSPolicy, []corsRoute) {
+	base := CORSPolicy{
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept-Language"},
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           86400,
+	}
+
+	def := base
+	def.AllowedOrigins = cfg.CORSOrigins
+
+	admin := base
+	admin.AllowedOrigins = cfg.CORSAdminOrigins
+	if len(admin.AllowedOrigins) == 0 {
+		admin.AllowedOrigins = cfg.CORSOrigins
+	}
+
+	routes := []corsRoute{{path: "/admin", policy: &admin}}
+	if len(cfg.CORSPublicOrigins) > 0 {
+		public := base
+		public.AllowedOrigins = cfg.CORSPublicOrigins
+		public.AllowedMethods = []string{"GET", "OPTIONS"}
+		public.AllowCredentials = false
+		routes = append(routes, corsRoute{path: "/questions", exact: true, policy: &public})
+	}
+	return &def, routes
+}
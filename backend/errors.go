@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Idioma por defecto de los mensajes de error (el de los clientes actuales)
+const defaultErrorLang = "es"
+
+// Respuesta de error de la API: code es estable y pensado para programas;
+// message es el texto legible en el idioma pedido por el cliente
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+	Field   string `json:"field,omitempty"`
+	// Datos adicionales de algunos errores (p. ej. motivo y fin de una suspensión)
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Mensajes por código e idioma
+
+var errorMessages = map[string]map[string]string{
+	"TOKEN_MISSING":                {"es": "Token faltante", "en": "Missing token"},
+	"TOKEN_INVALID":                {"es": "Token inválido", "en": "Invalid token"},
+	"FORBIDDEN":                    {"es": "No autorizado", "en": "Not authorized"},
+	"INVALID_REQUEST":              {"es": "Solicitud inválida", "en": "Invalid request"},
+	"USER_ID_MISSING":              {"es": "ID de usuario faltante", "en": "Missing user ID"},
+	"USER_ID_INVALID":              {"es": "ID de usuario no válido", "en": "Invalid user ID"},
+	"REGISTER_FIELDS_REQUIRED":     {"es": "Email, username y contraseña son requeridos", "en": "Email, username and password are required"},
+	"USER_FIELDS_REQUIRED":         {"es": "Email, username, password y role son requeridos", "en": "Email, username, password and role are required"},
+	"ROLE_REQUIRED":                {"es": "Role requerido", "en": "Role is required"},
+	"ROLE_INVALID":                 {"es": "Rol inválido (user o admin)", "en": "Invalid role (user or admin)"},
+	"USER_EXISTS":                  {"es": "Usuario ya existe", "en": "User already exists"},
+	"USER_NOT_FOUND":               {"es": "Usuario no encontrado", "en": "User not found"},
+	"INVALID_CREDENTIALS":          {"es": "Credenciales inválidas", "en": "Invalid credentials"},
+	"PASSWORD_HASH_FAILED":         {"es": "Error al encriptar contraseña", "en": "Could not hash password"},
+	"REGISTER_FAILED":              {"es": "Error al registrar", "en": "Registration failed"},
+	"USER_CREATE_FAILED":           {"es": "Error al crear usuario", "en": "Could not create user"},
+	"USERS_FETCH_FAILED":           {"es": "Error al obtener usuarios", "en": "Could not fetch users"},
+	"USER_READ_FAILED":             {"es": "Error al procesar usuario", "en": "Could not read user"},
+	"ROLE_UPDATE_FAILED":           {"es": "Error al actualizar rol", "en": "Could not update role"},
+	"USER_DELETE_FAILED":           {"es": "Error al eliminar usuario", "en": "Could not delete user"},
+	"ATTEMPT_SAVE_FAILED":          {"es": "Error al guardar el intento", "en": "Could not save attempt"},
+	"SUMMARY_SAVE_FAILED":          {"es": "Error al guardar resumen", "en": "Could not save summary"},
+	"ATTEMPTS_FETCH_FAILED":        {"es": "Error al obtener intentos", "en": "Could not fetch attempts"},
+	"ATTEMPT_READ_FAILED":          {"es": "Error al procesar intento", "en": "Could not read attempt"},
+	"SUMMARY_FETCH_FAILED":         {"es": "Error al obtener resumen", "en": "Could not fetch summary"},
+	"SUMMARY_READ_FAILED":          {"es": "Error al procesar resumen", "en": "Could not read summary"},
+	"QUESTIONS_FETCH_FAILED":       {"es": "Error al obtener preguntas", "en": "Could not fetch questions"},
+	"QUESTION_READ_FAILED":         {"es": "Error al procesar pregunta", "en": "Could not read question"},
+	"QUESTION_FETCH_FAILED":        {"es": "Error al obtener pregunta", "en": "Could not fetch question"},
+	"QUESTION_SAVE_FAILED":         {"es": "Error al guardar pregunta", "en": "Could not save question"},
+	"QUESTION_NOT_FOUND":           {"es": "Pregunta no encontrada", "en": "Question not found"},
+	"QUESTION_ID_INVALID":          {"es": "ID de pregunta inválido", "en": "Invalid question ID"},
+	"QUESTION_TYPE_INVALID":        {"es": "Tipo de pregunta inválido", "en": "Invalid question type"},
+	"QUESTION_TEXT_REQUIRED":       {"es": "La pregunta es requerida", "en": "Question text is required"},
+	"CORRECT_ANSWER_REQUIRED":      {"es": "correct_answer es requerido", "en": "correct_answer is required"},
+	"CORRECT_ANSWERS_REQUIRED":     {"es": "correct_answers es requerido para multi_select", "en": "correct_answers is required for multi_select"},
+	"MULTI_OPTION_INVALID":         {"es": "Las opciones de multi_select no pueden contener \"|\"", "en": "multi_select options cannot contain \"|\""},
+	"CORRECT_ANSWER_NOT_NUMERIC":   {"es": "correct_answer debe ser numérico", "en": "correct_answer must be numeric"},
+	"CORRECT_ANSWER_NOT_BOOLEAN":   {"es": "correct_answer debe ser True o False", "en": "correct_answer must be True or False"},
+	"NO_QUESTIONS_AVAILABLE":       {"es": "No hay preguntas disponibles", "en": "No questions available"},
+	"INVALID_PARAMETER":            {"es": "Parámetro inválido", "en": "Invalid parameter"},
+	"TOKEN_REQUIRED_FOR_EXCLUDE":   {"es": "Token requerido para excluir preguntas respondidas", "en": "A token is required to exclude answered questions"},
+	"STATS_FETCH_FAILED":           {"es": "Error al obtener estadísticas", "en": "Could not fetch statistics"},
+	"REVIEW_FETCH_FAILED":          {"es": "Error al obtener repaso", "en": "Could not fetch review questions"},
+	"REVIEW_CARD_NOT_FOUND":        {"es": "Pregunta no programada para repaso", "en": "Question is not scheduled for review"},
+	"REVIEW_CARD_NOT_DUE":          {"es": "La pregunta aún no toca repasarla", "en": "Question is not due for review yet"},
+	"REVIEW_SAVE_FAILED":           {"es": "Error al guardar repaso", "en": "Could not save review"},
+	"QUIZ_MODE_INVALID":            {"es": "Modo de quiz inválido", "en": "Invalid quiz mode"},
+	"TIME_LIMITS_INVALID":          {"es": "Límites de tiempo inválidos", "en": "Invalid time limits"},
+	"SESSION_START_FAILED":         {"es": "Error al iniciar el quiz", "en": "Could not start quiz"},
+	"SESSION_ID_INVALID":           {"es": "ID de sesión inválido", "en": "Invalid session ID"},
+	"SESSION_NOT_FOUND":            {"es": "Sesión no encontrada", "en": "Session not found"},
+	"SESSION_FETCH_FAILED":         {"es": "Error al obtener sesión", "en": "Could not fetch session"},
+	"SESSION_FINISHED":             {"es": "La sesión ya finalizó", "en": "Session already finished"},
+	"SESSION_FINISH_FAILED":        {"es": "Error al finalizar sesión", "en": "Could not finish session"},
+	"SESSION_QUESTIONS_INVALID":    {"es": "Indica entre 1 y 100 preguntas existentes y sin repetir", "en": "Provide 1 to 100 existing, distinct questions"},
+	"QUESTION_NOT_IN_SESSION":      {"es": "La pregunta no pertenece a la sesión", "en": "Question is not part of this session"},
+	"QUESTION_ALREADY_ANSWERED":    {"es": "La pregunta ya se respondió en esta sesión", "en": "Question already answered in this session"},
+	"RATING_FETCH_FAILED":          {"es": "Error al obtener rating", "en": "Could not fetch rating"},
+	"RATING_UPDATE_FAILED":         {"es": "Error al actualizar rating", "en": "Could not update rating"},
+	"ADAPTIVE_NOT_PENDING":         {"es": "La pregunta no es la pendiente del modo adaptativo", "en": "Question is not the pending adaptive question"},
+	"LANG_INVALID":                 {"es": "Idioma inválido", "en": "Invalid language"},
+	"TRANSLATION_FIELDS_REQUIRED":  {"es": "question y correct_answer son requeridos", "en": "question and correct_answer are required"},
+	"TRANSLATION_OPTIONS_MISMATCH": {"es": "Las respuestas traducidas deben tener la misma cantidad de opciones que el original", "en": "Translated answers must have the same number of options as the original"},
+	"TRANSLATIONS_FETCH_FAILED":    {"es": "Error al obtener traducciones", "en": "Could not fetch translations"},
+	"TRANSLATION_SAVE_FAILED":      {"es": "Error al guardar traducción", "en": "Could not save translation"},
+	"TRANSLATION_DELETE_FAILED":    {"es": "Error al eliminar traducción", "en": "Could not delete translation"},
+	"CORS_ORIGIN_FORBIDDEN":        {"es": "Origen no permitido", "en": "Origin not allowed"},
+	"CORS_METHOD_FORBIDDEN":        {"es": "Método no permitido para CORS", "en": "Method not allowed for CORS"},
+	"CORS_HEADER_FORBIDDEN":        {"es": "Cabecera no permitida para CORS", "en": "Header not allowed for CORS"},
+	"TRANSLATION_NOT_FOUND":        {"es": "Traducción no encontrada", "en": "Translation not found"},
+	"RATE_LIMITED":                 {"es": "Demasiadas solicitudes, inténtalo más tarde", "en": "Too many requests, try again later"},
+	"PASSWORD_TOO_SHORT":           {"es": "La contraseña es demasiado corta", "en": "Password is too short"},
+	"PASSWORD_TOO_LONG":            {"es": "La contraseña es demasiado larga", "en": "Password is too long"},
+	"PASSWORD_NEEDS_LETTER":        {"es": "La contraseña debe contener al menos una letra", "en": "Password must contain a letter"},
+	"PASSWORD_NEEDS_UPPER":         {"es": "La contraseña debe contener al menos una mayúscula", "en": "Password must contain an uppercase letter"},
+	"PASSWORD_NEEDS_LOWER":         {"es": "La contraseña debe contener al menos una minúscula", "en": "Password must contain a lowercase letter"},
+	"PASSWORD_NEEDS_DIGIT":         {"es": "La contraseña debe contener al menos un número", "en": "Password must contain a digit"},
+	"PASSWORD_NEEDS_SYMBOL":        {"es": "La contraseña debe contener al menos un símbolo", "en": "Password must contain a symbol"},
+	"PASSWORD_CONTAINS_IDENTITY":   {"es": "La contraseña no puede contener el email ni el username", "en": "Password must not contain your email or username"},
+	"PASSWORD_FIELDS_REQUIRED":     {"es": "La contraseña actual y la nueva son requeridas", "en": "Current and new password are required"},
+	"CURRENT_PASSWORD_INVALID":     {"es": "La contraseña actual no es correcta", "en": "Current password is incorrect"},
+	"PASSWORD_UPDATE_FAILED":       {"es": "Error al actualizar la contraseña", "en": "Could not update password"},
+	"PASSWORD_RESET_FAILED":        {"es": "Error al solicitar el restablecimiento", "en": "Could not request password reset"},
+	"RESET_TOKEN_INVALID":          {"es": "El enlace no es válido o ha caducado", "en": "Reset link is invalid or has expired"},
+	"EMAIL_REQUIRED":               {"es": "Email requerido", "en": "Email is required"},
+	"EMAIL_INVALID":                {"es": "Email inválido", "en": "Invalid email"},
+	"EMAIL_NOT_VERIFIED":           {"es": "Debes verificar tu email", "en": "You must verify your email"},
+	"EMAIL_VERIFY_FAILED":          {"es": "Error al verificar el email", "en": "Could not verify email"},
+	"VERIFICATION_TOKEN_INVALID":   {"es": "El enlace de verificación no es válido o ha caducado", "en": "Verification link is invalid or has expired"},
+	"LOGIN_LOCKED":                 {"es": "Demasiados intentos fallidos, inténtalo más tarde", "en": "Too many failed attempts, try again later"},
+	"LOGIN_FAILED":                 {"es": "Error al iniciar sesión", "en": "Could not log in"},
+	"MFA_CHALLENGE_INVALID":        {"es": "El desafío de inicio de sesión no es válido o ha caducado", "en": "Login challenge is invalid or has expired"},
+	"MFA_CODE_REQUIRED":            {"es": "Código de verificación requerido", "en": "Verification code is required"},
+	"MFA_CODE_INVALID":             {"es": "El código de verificación no es correcto", "en": "Verification code is incorrect"},
+	"MFA_CHECK_FAILED":             {"es": "Error al comprobar el código de verificación", "en": "Could not check verification code"},
+	"TOTP_ALREADY_ENABLED":         {"es": "La verificación en dos pasos ya está activada", "en": "Two-factor authentication is already enabled"},
+	"TOTP_NOT_ENABLED":             {"es": "La verificación en dos pasos no está activada", "en": "Two-factor authentication is not enabled"},
+	"TOTP_NOT_SETUP":               {"es": "Primero inicia la configuración de la verificación en dos pasos", "en": "Start two-factor setup first"},
+	"TOTP_SETUP_FAILED":            {"es": "Error al configurar la verificación en dos pasos", "en": "Could not set up two-factor authentication"},
+	"TOTP_DISABLE_FAILED":          {"es": "Error al desactivar la verificación en dos pasos", "en": "Could not disable two-factor authentication"},
+	"TOTP_REQUIRED":                {"es": "Debes iniciar sesión con verificación en dos pasos", "en": "You must log in with two-factor authentication"},
+	"OIDC_DISABLED":                {"es": "El login con proveedor externo no está configurado", "en": "External provider login is not configured"},
+	"OIDC_PROVIDER_UNAVAILABLE":    {"es": "No se pudo contactar con el proveedor de identidad", "en": "Could not reach the identity provider"},
+	"OIDC_PROVIDER_ERROR":          {"es": "El proveedor de identidad rechazó el inicio de sesión", "en": "The identity provider rejected the login"},
+	"OIDC_STATE_INVALID":           {"es": "El inicio de sesión caducó o no es válido, vuelve a intentarlo", "en": "Login expired or is invalid, please try again"},
+	"OIDC_TOKEN_INVALID":           {"es": "La respuesta del proveedor de identidad no es válida", "en": "Invalid response from the identity provider"},
+	"OIDC_EMAIL_REQUIRED":          {"es": "El proveedor de identidad no compartió tu email", "en": "The identity provider did not share your email"},
+	"OIDC_ACCOUNT_CONFLICT":        {"es": "Ya existe una cuenta con ese email y el proveedor no lo ha verificado", "en": "An account with that email exists and the provider did not verify it"},
+	"OIDC_ACCOUNT_NOT_FOUND":       {"es": "No hay ninguna cuenta asociada a esta identidad", "en": "No account is linked to this identity"},
+	"OIDC_LOGIN_FAILED":            {"es": "Error al iniciar sesión con el proveedor de identidad", "en": "Could not log in with the identity provider"},
+	"API_KEY_INVALID":              {"es": "Clave de API inválida, revocada o caducada", "en": "API key is invalid, revoked or expired"},
+	"API_KEY_SCOPE_MISSING":        {"es": "La clave de API no tiene permiso para esta operación", "en": "API key is not allowed to perform this operation"},
+	"API_KEY_NAME_REQUIRED":        {"es": "Nombre de la clave requerido", "en": "Key name is required"},
+	"API_KEY_SCOPE_INVALID":        {"es": "Scopes inválidos", "en": "Invalid scopes"},
+	"API_KEY_EXPIRY_INVALID":       {"es": "La fecha de caducidad debe ser futura", "en": "Expiry must be in the future"},
+	"API_KEY_ID_INVALID":           {"es": "ID de clave no válido", "en": "Invalid key ID"},
+	"API_KEY_NOT_FOUND":            {"es": "Clave de API no encontrada", "en": "API key not found"},
+	"API_KEYS_FETCH_FAILED":        {"es": "Error al obtener las claves de API", "en": "Could not fetch API keys"},
+	"API_KEY_CREATE_FAILED":        {"es": "Error al crear la clave de API", "en": "Could not create API key"},
+	"API_KEY_REVOKE_FAILED":        {"es": "Error al revocar la clave de API", "en": "Could not revoke API key"},
+	"DISPLAY_NAME_INVALID":         {"es": "El nombre visible es demasiado largo", "en": "Display name is too long"},
+	"AVATAR_URL_INVALID":           {"es": "La URL del avatar no es válida", "en": "Invalid avatar URL"},
+	"CATEGORIES_INVALID":           {"es": "Categorías preferidas inválidas", "en": "Invalid preferred categories"},
+	"USERNAME_INVALID":             {"es": "El username debe tener entre 3 y 32 letras, números, '.', '_' o '-'", "en": "Username must be 3 to 32 letters, digits, '.', '_' or '-'"},
+	"USERNAME_TAKEN":               {"es": "Ese username ya está en uso", "en": "Username is already taken"},
+	"EMAIL_TAKEN":                  {"es": "Ese email ya está en uso", "en": "Email is already in use"},
+	"CURRENT_PASSWORD_REQUIRED":    {"es": "Confirma la operación con tu contraseña actual", "en": "Confirm this operation with your current password"},
+	"PROFILE_UPDATE_FAILED":        {"es": "Error al actualizar el perfil", "en": "Could not update profile"},
+	"DELETE_CONFIRMATION_INVALID":  {"es": "Escribe el email de la cuenta para confirmar", "en": "Type the account email to confirm"},
+	"LAST_ADMIN":                   {"es": "No se puede eliminar el último administrador", "en": "Cannot delete the last administrator"},
+	"TOTP_REQUIRED_FOR_ROLE":       {"es": "Tu rol exige la verificación en dos pasos", "en": "Your role requires two-factor authentication"},
+	"ACCOUNT_SUSPENDED":            {"es": "La cuenta está suspendida", "en": "This account is suspended"},
+	"ACCOUNT_DELETED":              {"es": "La cuenta ha sido eliminada", "en": "This account has been deleted"},
+	"SUSPEND_SELF":                 {"es": "No puedes suspender ni eliminar tu propia cuenta desde administración", "en": "You cannot suspend or delete your own account from administration"},
+	"SUSPENSION_REASON_REQUIRED":   {"es": "Indica el motivo de la suspensión", "en": "A suspension reason is required"},
+	"SUSPENSION_UNTIL_INVALID":     {"es": "El fin de la suspensión debe ser una fecha futura", "en": "Suspension end must be in the future"},
+	"USER_SUSPEND_FAILED":          {"es": "Error al actualizar la suspensión", "en": "Could not update suspension"},
+	"USER_RESTORE_FAILED":          {"es": "Error al restaurar el usuario", "en": "Could not restore user"},
+	"USER_NOT_DELETED":             {"es": "Solo se pueden purgar usuarios eliminados previamente", "en": "Only previously deleted users can be purged"},
+	"USER_ERASED":                  {"es": "La cuenta fue anonimizada y no se puede restaurar", "en": "This account was anonymized and cannot be restored"},
+	"USER_ERASE_FAILED":            {"es": "Error al borrar los datos personales", "en": "Could not erase personal data"},
+	"AUDIT_WRITE_FAILED":           {"es": "No se pudo registrar la auditoría; el cambio no se aplicó", "en": "Could not write the audit log; the change was not applied"},
+	"AUDIT_FETCH_FAILED":           {"es": "Error al consultar la auditoría", "en": "Could not fetch audit log"},
+	"EXPORT_FAILED":                {"es": "Error al exportar los datos", "en": "Could not export data"},
+	"USER_PURGE_FAILED":            {"es": "Error al purgar el usuario", "en": "Could not purge user"},
+}
+
+// Idioma del mensaje: lang o Accept-Language si hay mensajes en ese idioma
+
+func errorLang(r *http.Request) string {
+	candidates := preferredLangs(r.Header.Get("Accept-Language"))
+	if lang := normalizeLang(r.URL.Query().Get("lang")); lang != "" {
+		candidates = append([]string{lang}, candidates...)
+	}
+	for _, lang := range candidates {
+		if _, ok := errorMessages["TOKEN_MISSING"][lang]; ok {
+			return lang
+		}
+	}
+	return defaultErrorLang
+}
+
+func errorMessage(code, lang string) string {
+	msgs, ok := errorMessages[code]
+	if !ok {
+		return code
+	}
+	if msg, ok := msgs[lang]; ok {
+		return msg
+	}
+	return msgs[defaultErrorLang]
+}
+
+// Responder con un error JSON: {"error": {"code", "message", "status"}}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	writeFieldError(w, r, status, code, "")
+}
+
+// Igual que writeError indicando el campo o parámetro que causó el error
+
+func writeFieldError(w http.ResponseWriter, r *http.Request, status int, code, field string) {
+	writeAPIError(w, r, APIError{Code: code, Status: status, Field: field})
+}
+
+// Igual que writeError añadiendo datos en "details"
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code string, details map[string]interface{}) {
+	writeAPIError(w, r, APIError{Code: code, Status: status, Details: details})
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, e APIError) {
+	lang := errorLang(r)
+	e.Message = errorMessage(e.Code, lang)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	_ = json.NewEncoder(w).Encode(map[string]APIError{"error": e})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// Tipos de pregunta soportados
+const (
+	QuestionMultiple    = "multiple"
+	QuestionBoolean     = "boolean"
+	QuestionMultiSelect = "multi_select"
+	QuestionText        = "text"
+	QuestionNumeric     = "numeric"
+)
+
+var questionTypes = map[string]bool{
+	QuestionMultiple:    true,
+	QuestionBoolean:     true,
+	QuestionMultiSelect: true,
+	QuestionText:        true,
+	QuestionNumeric:     true,
+}
+
+// Columnas de tipo y respuestas aceptadas (además de correct_answer)
+const gradingColumns = `COALESCE(type, 'multiple'), COALESCE(correct_answers, '{}'), tolerance`
+
+// Cargar la clave de corrección de una pregunta
+
+func loadQuestionKey(questionID int) (Question, error) {
+	q := Question{ID: questionID}
+	err := DB.QueryRow(`SELECT correct_answer, `+gradingColumns+` FROM questions WHERE id = $1`, questionID).
+		Scan(&q.CorrectAnswer, &q.Type, pq.Array(&q.CorrectAnswers), &q.Tolerance)
+	return q, err
+}
+
+// Corregir una respuesta según el tipo de pregunta. Para multi_select se usa
+// selectedAnswers; para el resto selectedAnswer.
+
+func (q Question) Grade(selected string, selectedMulti []string) bool {
+	switch q.Type {
+	case QuestionBoolean:
+		a, okA := parseBoolAnswer(selected)
+		b, okB := parseBoolAnswer(q.CorrectAnswer)
+		return okA && okB && a == b
+
+	case QuestionMultiSelect:
+		if len(selectedMulti) == 0 && selected != "" {
+			selectedMulti = splitMultiAnswer(selected)
+		}
+		return sameAnswerSet(selectedMulti, q.CorrectAnswers)
+
+	case QuestionText:
+		got := normalizeAnswer(selected)
+		if got == "" {
+			return false
+		}
+		for _, accepted := range append([]string{q.CorrectAnswer}, q.CorrectAnswers...) {
+			want := normalizeAnswer(accepted)
+			if got == want {
+				return true
+			}
+			// Tolerar un error tipográfico por cada 8 caracteres
+			if maxTypos := len([]rune(want)) / 8; maxTypos > 0 && levenshtein(got, want) <= maxTypos {
+				return true
+			}
+		}
+		return false
+
+	case QuestionNumeric:
+		got, err := parseNumericAnswer(selected)
+		if err != nil {
+			return false
+		}
+		want, err := parseNumericAnswer(q.CorrectAnswer)
+		if err != nil {
+			return false
+		}
+		tolerance := 1e-9
+		if q.Tolerance != nil && *q.Tolerance > tolerance {
+			tolerance = *q.Tolerance
+		}
+		return math.Abs(got-want) <= tolerance
+
+	default:
+		return strings.TrimSpace(selected) == strings.TrimSpace(q.CorrectAnswer)
+	}
+}
+
+// Separador de las respuestas de selección múltiple guardadas como texto; las
+// opciones de multi_select no pueden contenerlo (ver validMultiOptions)
+const multiAnswerSeparator = "|"
+
+// Representación de una respuesta de selección múltiple para guardarla en attempts
+func joinMultiAnswer(answers []string) string {
+	sorted := append([]string(nil), answers...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " "+multiAnswerSeparator+" ")
+}
+
+func splitMultiAnswer(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, multiAnswerSeparator) {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Indica si ninguna opción contiene el separador de respuestas múltiples
+
+func validMultiOptions(lists ...[]string) bool {
+	for _, list := range lists {
+		for _, o := range list {
+			if strings.Contains(o, multiAnswerSeparator) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameAnswerSet(got, want []string) bool {
+	if len(want) == 0 {
+		return false
+	}
+	set := map[string]bool{}
+	for _, w := range want {
+		set[strings.TrimSpace(w)] = true
+	}
+	seen := map[string]bool{}
+	for _, g := range got {
+		g = strings.TrimSpace(g)
+		if !set[g] {
+			return false
+		}
+		seen[g] = true
+	}
+	return len(seen) == len(set)
+}
+
+func parseBoolAnswer(s string) (bool, bool) {
+	switch normalizeAnswer(s) {
+	case "true", "verdadero", "si", "yes", "v", "1":
+		return true, true
+	case "false", "falso", "no", "f", "0":
+		return false, true
+	}
+	return false, false
+}
+
+func parseNumericAnswer(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	// Aceptar coma decimal si no hay punto
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	s = strings.ReplaceAll(s, " ", "")
+	return strconv.ParseFloat(s, 64)
+}
+
+// Normalizar texto libre: minúsculas, sin acentos, sin puntuación y espacios simples
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+	"à", "a", "è", "e", "ì", "i", "ò", "o", "ù", "u", "ç", "c",
+)
+
+func normalizeAnswer(s string) string {
+	s = accentReplacer.Replace(strings.ToLower(strings.TrimSpace(s)))
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteRune(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		case unicode.IsSpace(r) || unicode.IsPunct(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Crear una pregunta manualmente (solo admin); permite los tipos que OpenTDB no ofrece
+
+func CreateQuestionAdmin(w http.ResponseWriter, r *http.Request) {
+	var q Question
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	if q.Type == "" {
+		q.Type = QuestionMultiple
+	}
+	if !questionTypes[q.Type] {
+		writeError(w, r, http.StatusBadRequest, "QUESTION_TYPE_INVALID")
+		return
+	}
+	if strings.TrimSpace(q.Question) == "" {
+		writeError(w, r, http.StatusBadRequest, "QUESTION_TEXT_REQUIRED")
+		return
+	}
+
+	switch q.Type {
+	case QuestionMultiSelect:
+		if len(q.CorrectAnswers) == 0 {
+			writeError(w, r, http.StatusBadRequest, "CORRECT_ANSWERS_REQUIRED")
+			return
+		}
+		if !validMultiOptions(q.CorrectAnswers, q.IncorrectAnswers) {
+			writeError(w, r, http.StatusBadRequest, "MULTI_OPTION_INVALID")
+			return
+		}
+		// correct_answer guarda la representación legible de la respuesta
+		q.CorrectAnswer = joinMultiAnswer(q.CorrectAnswers)
+	case QuestionBoolean:
+		v, ok := parseBoolAnswer(q.CorrectAnswer)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "CORRECT_ANSWER_NOT_BOOLEAN")
+			return
+		}
+		q.CorrectAnswer, q.IncorrectAnswers = "True", []string{"False"}
+		if !v {
+			q.CorrectAnswer, q.IncorrectAnswers = "False", []string{"True"}
+		}
+	case QuestionNumeric:
+		if _, err := parseNumericAnswer(q.CorrectAnswer); err != nil {
+			writeError(w, r, http.StatusBadRequest, "CORRECT_ANSWER_NOT_NUMERIC")
+			return
+		}
+	default:
+		if strings.TrimSpace(q.CorrectAnswer) == "" {
+			writeError(w, r, http.StatusBadRequest, "CORRECT_ANSWER_REQUIRED")
+			return
+		}
+	}
+	if q.IncorrectAnswers == nil {
+		q.IncorrectAnswers = []string{}
+	}
+	if q.Lang = normalizeLang(q.Lang); q.Lang == "" {
+		q.Lang = defaultSourceLang
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`
+		INSERT INTO questions (question, correct_answer, incorrect_answers, categoria, dificultad, type, correct_answers, tolerance, source_lang)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9)
+		RETURNING id`,
+		q.Question, q.CorrectAnswer, pq.Array(q.IncorrectAnswers), q.Categoria, q.Dificultad,
+		q.Type, pq.Array(q.CorrectAnswers), q.Tolerance, q.Lang).Scan(&q.ID)
+	if err != nil {
+		requestLogger(r).Error("Error al crear pregunta", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
+		return
+	}
+	if err := recordAudit(tx, r, auditQuestionCreate, auditTargetQuestion, q.ID, nil, q); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(q)
+}
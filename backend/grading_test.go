@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuestionGrade(t *testing.T) {
+	tolerance := 0.01
+	multiple := Question{Type: QuestionMultiple, CorrectAnswer: "París"}
+	boolean := Question{Type: QuestionBoolean, CorrectAnswer: "True"}
+	multi := Question{Type: QuestionMultiSelect, CorrectAnswers: []string{"Rojo", "Azul"}}
+	text := Question{Type: QuestionText, CorrectAnswer: "Leonardo da Vinci", CorrectAnswers: []string{"Da Vinci"}}
+	numeric := Question{Type: QuestionNumeric, CorrectAnswer: "3.14159", Tolerance: &tolerance}
+
+	tests := []struct {
+		name     string
+		q        Question
+		selected string
+		multi    []string
+		want     bool
+	}{
+		{"múltiple exacta", multiple, " París ", nil, true},
+		{"múltiple distinta", multiple, "Paris", nil, false},
+		{"booleana en español", boolean, "verdadero", nil, true},
+		{"booleana falsa", boolean, "no", nil, false},
+		{"booleana no reconocida", boolean, "quizá", nil, false},
+		{"multi_select conjunto exacto", multi, "", []string{"Azul", "Rojo"}, true},
+		{"multi_select incompleta", multi, "", []string{"Rojo"}, false},
+		{"multi_select con opción de más", multi, "", []string{"Rojo", "Azul", "Verde"}, false},
+		{"multi_select guardada como texto", multi, joinMultiAnswer([]string{"Rojo", "Azul"}), nil, true},
+		{"texto normalizado", text, "leonardo  DA vinci.", nil, true},
+		{"texto alternativo", text, "da vinci", nil, true},
+		{"texto con errata tolerada", text, "Leonardo da Vinchi", nil, true},
+		{"texto con demasiadas erratas", text, "Leonard de Vinchy", nil, false},
+		{"texto vacío", text, "  ", nil, false},
+		{"numérica con coma", numeric, "3,14", nil, true},
+		{"numérica fuera de tolerancia", numeric, "3.1", nil, false},
+		{"numérica inválida", numeric, "pi", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Grade(tt.selected, tt.multi); got != tt.want {
+				t.Errorf("Grade(%q, %v) = %v, want %v", tt.selected, tt.multi, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"año", "ano", 1},
+		{"igual", "igual", 0},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMultiAnswerRoundTrip(t *testing.T) {
+	got := splitMultiAnswer(joinMultiAnswer([]string{"Rojo", "Azul", "Verde"}))
+	want := []string{"Azul", "Rojo", "Verde"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitMultiAnswer(joinMultiAnswer(...)) = %v, want %v", got, want)
+	}
+}
+
+func TestValidMultiOptions(t *testing.T) {
+	if !validMultiOptions([]string{"A", "B"}, []string{"C"}) {
+		t.Error("validMultiOptions rechazó opciones sin separador")
+	}
+	if validMultiOptions([]string{"A"}, []string{"B | C"}) {
+		t.Error("validMultiOptions aceptó una opción con separador")
+	}
+}
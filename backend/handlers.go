@@ -1,11 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,7 +19,7 @@ func GetUserAttempts(w http.ResponseWriter, r *http.Request) {
 	// Validar token en el header Authorization
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Token faltante", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_MISSING")
 		return
 	}
 	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
@@ -25,27 +27,28 @@ func GetUserAttempts(w http.ResponseWriter, r *http.Request) {
 	// Verificar token y extraer claims
 	claims, err := VerifyToken(tokenStr)
 	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
 		return
 	}
 
 	// Extraer userID del token
 	userID, ok := claims["user"].(float64)
 	if !ok {
-		http.Error(w, "ID de usuario no válido", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
 		return
 	}
 
 	// Consultar intentos del usuario
 	rows, err := DB.Query(`
-		SELECT a.id, a.user_id, q.question, a.selected_answer, a.is_correct, a.answered_at, u.username
+		SELECT a.id, a.user_id, q.question, a.selected_answer, a.is_correct, a.answered_at, u.username,
+		       a.time_taken_ms, COALESCE(a.timed_out, false)
 		FROM attempts a
 		JOIN questions q ON a.question_id = q.id
 		JOIN users u ON a.user_id = u.id
 		WHERE a.user_id = $1
 		ORDER BY a.answered_at DESC`, int(userID))
 	if err != nil {
-		http.Error(w, "Error al obtener intentos del usuario", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPTS_FETCH_FAILED")
 		return
 	}
 	defer rows.Close()
@@ -54,8 +57,9 @@ func GetUserAttempts(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var a AttemptView
 		var answeredAt time.Time
-		if err := rows.Scan(&a.ID, &a.UserID, &a.Question, &a.SelectedAnswer, &a.IsCorrect, &answeredAt, &a.Username); err != nil {
-			http.Error(w, "Error al procesar intento", http.StatusInternalServerError)
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Question, &a.SelectedAnswer, &a.IsCorrect, &answeredAt, &a.Username,
+			&a.TimeTakenMs, &a.TimedOut); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "ATTEMPT_READ_FAILED")
 			return
 		}
 		a.AnsweredAt = answeredAt.Format(time.RFC3339)
@@ -71,137 +75,259 @@ func GetUserAttempts(w http.ResponseWriter, r *http.Request) {
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
 		return
 	}
 
 	if user.Email == "" || user.Password == "" || user.Username == "" {
-		http.Error(w, "Email, username y contraseña son requeridos", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "REGISTER_FIELDS_REQUIRED")
+		return
+	}
+	user.Email = strings.TrimSpace(user.Email)
+	if !validEmail(user.Email) {
+		writeFieldError(w, r, http.StatusBadRequest, "EMAIL_INVALID", "email")
+		return
+	}
+	user.Username = strings.TrimSpace(user.Username)
+	if !checkUsernameAvailable(w, r, user.Username, 0) {
+		return
+	}
+	if code := checkPassword(user.Password, user.Email, user.Username); code != "" {
+		writeFieldError(w, r, http.StatusBadRequest, code, "password")
 		return
 	}
 
 	// Encriptar contraseña
-	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), AppConfig.BcryptCost)
 	if err != nil {
-		http.Error(w, "Error al encriptar contraseña", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_HASH_FAILED")
 		return
 	}
 
+	// La cuenta empieza sin verificar (email_verified_at NULL)
 	user.Role = "user"
-	if _, err := DB.Exec(`INSERT INTO users (email, username, password, role) VALUES ($1, $2, $3, $4)`,
-		user.Email, user.Username, string(hashed), user.Role); err != nil {
+	if err := DB.QueryRow(`INSERT INTO users (email, username, password, role) VALUES ($1, $2, $3, $4) RETURNING id`,
+		user.Email, user.Username, string(hashed), user.Role).Scan(&user.ID); err != nil {
 
-		log.Println("❌ Error al registrar usuario:", err)
+		requestLogger(r).Error("Error al registrar usuario", "error", err)
 
 		if strings.Contains(err.Error(), "duplicate key") {
-			http.Error(w, "Usuario ya existe", http.StatusConflict)
+			writeError(w, r, http.StatusConflict, "USER_EXISTS")
 			return
 		}
 
-		http.Error(w, "Error al registrar", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "REGISTER_FAILED")
 		return
 	}
 
+	registrationsTotal.Inc()
+	if err := sendVerificationEmail(r, user.ID, user.Email); err != nil {
+		requestLogger(r).Error("Error al enviar correo de verificación", "error", err)
+	}
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Registrado correctamente"})
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Registrado correctamente. Revisa tu correo para verificar tu email"})
 }
 
 // Login y emisión de token
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("📥 LoginHandler recibió una solicitud")
 	var creds User
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	// Bloqueo por intentos fallidos desde la IP
+	ipKey := loginIPKey(r)
+	if rejectLockedLogin(w, r, ipKey) {
 		return
 	}
 
 	// Buscar por email o username
 	var user User
 	row := DB.QueryRow(`
-        SELECT id, email, username, password, role 
+        SELECT id, email, username, password, role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
         FROM users 
-        WHERE email = $1 OR username = $2`, creds.Email, creds.Username)
+        WHERE (email = $1 OR username = $2) AND deleted_at IS NULL`, creds.Email, creds.Username)
 
-	if err := row.Scan(&user.ID, &user.Email, &user.Username, &user.Password, &user.Role); err != nil {
-		http.Error(w, "Usuario no encontrado", http.StatusUnauthorized)
+	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.Password, &user.Role, &user.EmailVerified, &user.TwoFactorEnabled)
+	if err != nil && err != sql.ErrNoRows {
+		requestLogger(r).Error("Error al buscar usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "LOGIN_FAILED")
+		return
+	}
+	if err == sql.ErrNoRows {
+		// Mismo coste y misma respuesta que una contraseña incorrecta para no
+		// revelar que el usuario no existe
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(creds.Password))
+		if err := recordLoginFailure(r, ipKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeError(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS")
+		return
+	}
+
+	// Bloqueo por intentos fallidos contra la cuenta
+	accountKey := loginAccountKey(user.ID)
+	if rejectLockedLogin(w, r, accountKey) {
 		return
 	}
 
 	// Comparar contraseña encriptada
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
-		http.Error(w, "Credenciales inválidas", http.StatusUnauthorized)
+		if err := recordLoginFailure(r, ipKey, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeError(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS")
 		return
 	}
 
+	// Con UNVERIFIED_RESTRICT=login solo se informa tras comprobar la contraseña
+	if !user.EmailVerified && unverifiedRestricted(restrictLogin) {
+		writeError(w, r, http.StatusForbidden, "EMAIL_NOT_VERIFIED")
+		return
+	}
+	// La suspensión también se comunica solo con la contraseña correcta
+	if rejectInactiveAccount(w, r, user.ID) {
+		return
+	}
+
+	// Con 2FA activo la contraseña solo da un token de desafío para /login/2fa;
+	// los fallos de la cuenta se mantienen hasta completar el segundo paso
+	if user.TwoFactorEnabled {
+		challenge, err := newMFAChallenge(user.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "LOGIN_FAILED")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(challenge)
+		return
+	}
+	issueSession(w, r, user, false)
+}
+
+// Emitir el JWT de sesión tras completar el login. mfa indica si se comprobó
+// el segundo factor; los roles de TOTP_REQUIRED_ROLES lo necesitan para sus rutas.
+
+func issueSession(w http.ResponseWriter, r *http.Request, user User, mfa bool) {
+	session, err := newSession(r, user, mfa)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "LOGIN_FAILED")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+func newSession(r *http.Request, user User, mfa bool) (map[string]interface{}, error) {
+	// Un login correcto borra los fallos de la cuenta (no los de la IP, para que
+	// un atacante no pueda reiniciarlos con una cuenta propia)
+	if err := rateLimitStore.Reset(loginAccountKey(user.ID)); err != nil {
+		requestLogger(r).Error("Error al reiniciar logins fallidos", "error", err)
+	}
+
 	// Generar token con userID y rol
-	token, _ := GenerateToken(user.Email, user.Role, user.ID)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":    token,
-		"role":     user.Role,
-		"user":     user.ID,
-		"username": user.Username,
-	})
+	token, err := GenerateToken(user.Email, user.Role, user.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+	session := map[string]interface{}{
+		"token":            token,
+		"role":             user.Role,
+		"user":             user.ID,
+		"username":         user.Username,
+		"emailVerified":    user.EmailVerified,
+		"twoFactorEnabled": user.TwoFactorEnabled,
+	}
+	if !user.TwoFactorEnabled && roleRequiresTOTP(user.Role) {
+		// Solo podrá usar las rutas comunes (p. ej. /user/2fa/setup) hasta activarlo
+		session["mfaEnrollmentRequired"] = true
+	}
+	return session, nil
 }
 
-// Guardar respuestas (intentos)
+// Guardar respuestas (intentos) del usuario del token; el userId del body se ignora
 
 func SaveAttemptAnswers(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
 	var answers []AttemptAnswer
 	if err := json.NewDecoder(r.Body).Decode(&answers); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
 		return
 	}
 
-	log.Printf("📥 Recibido %d respuestas", len(answers))
+	logger := requestLogger(r)
+	logger.Debug("Respuestas recibidas", "count", len(answers))
 
 	var correct, incorrect int
 	for _, a := range answers {
-		log.Printf("➡️ userID: %d, questionID: %d, selected: %s, correcto: %v",
-			a.UserID, a.QuestionID, a.SelectedAnswer, a.IsCorrect)
+		a.UserID = userID
+		// Corregir en el servidor según el tipo de pregunta
+		key, err := loadQuestionKey(a.QuestionID)
+		if err != nil {
+			logger.Error("Error al obtener pregunta", "error", err)
+			writeError(w, r, http.StatusBadRequest, "QUESTION_NOT_FOUND")
+			return
+		}
+		a.IsCorrect = gradeAnswer(key, a.SelectedAnswer, a.SelectedAnswers)
+		if key.Type == QuestionMultiSelect && len(a.SelectedAnswers) > 0 {
+			a.SelectedAnswer = joinMultiAnswer(a.SelectedAnswers)
+		}
+
+		logger.Debug("Respuesta corregida", "user_id", a.UserID, "question_id", a.QuestionID, "correct", a.IsCorrect)
 
-		_, err := DB.Exec(`
+		_, err = DB.Exec(`
             INSERT INTO attempts (user_id, question_id, selected_answer, is_correct)
             VALUES ($1, $2, $3, $4)`,
 			a.UserID, a.QuestionID, a.SelectedAnswer, a.IsCorrect)
 
 		if err != nil {
-			log.Println("❌ Error al guardar intento:", err)
-			http.Error(w, "Error al guardar el intento", http.StatusInternalServerError)
+			logger.Error("Error al guardar intento", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
 			return
 		}
 
+		recordAnswer("batch", a.IsCorrect)
 		if a.IsCorrect {
 			correct++
 		} else {
 			incorrect++
+			// Programar la pregunta fallada para repaso espaciado
+			if err := scheduleMissedQuestion(a.UserID, a.QuestionID); err != nil {
+				logger.Warn("No se pudo programar repaso", "error", err)
+			}
 		}
 	}
 
-	log.Printf("🧮 Guardando resumen para userID=%d: %d correctos, %d incorrectos", answers[0].UserID, correct, incorrect)
+	logger.Debug("Guardando resumen", "user_id", userID, "correct", correct, "incorrect", incorrect)
 
 	res, err := DB.Exec(`
 		UPDATE attempt_summary SET correct_count = $2, incorrect_count = $3 WHERE user_id = $1`,
-		answers[0].UserID, correct, incorrect)
+		userID, correct, incorrect)
 	if err != nil {
-		log.Println("❌ Error al actualizar resumen:", err)
-		http.Error(w, "Error al guardar resumen", http.StatusInternalServerError)
+		logger.Error("Error al actualizar resumen", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "SUMMARY_SAVE_FAILED")
 		return
 	}
 	rowsAffected, _ := res.RowsAffected()
 	if rowsAffected == 0 {
 		_, err = DB.Exec(`INSERT INTO attempt_summary (user_id, correct_count, incorrect_count) VALUES ($1, $2, $3)`,
-			answers[0].UserID, correct, incorrect)
+			userID, correct, incorrect)
 		if err != nil {
-			log.Println("❌ Error al insertar resumen:", err)
-			http.Error(w, "Error al guardar resumen", http.StatusInternalServerError)
+			logger.Error("Error al insertar resumen", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "SUMMARY_SAVE_FAILED")
 			return
 		}
 	}
 
+	quizzesCompletedTotal.Inc("batch")
+
 	// Obtener username para devolver en la respuesta
 	var username string
-	row := DB.QueryRow(`SELECT username FROM users WHERE id = $1`, answers[0].UserID)
+	row := DB.QueryRow(`SELECT username FROM users WHERE id = $1`, userID)
 	_ = row.Scan(&username) 
 
 	total := correct + incorrect
@@ -211,7 +337,7 @@ func SaveAttemptAnswers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := map[string]interface{}{
-		"userId":     answers[0].UserID,
+		"userId":     userID,
 		"username":   username,
 		"correct":    correct,
 		"incorrect":  incorrect,
@@ -226,16 +352,53 @@ func SaveAttemptAnswers(w http.ResponseWriter, r *http.Request) {
 // Obtener preguntas desde OpenTDB y guardarlas
 
 func FetchAndSaveQuestions(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get("https://opentdb.com/api.php?amount=10&type=multiple")
+	// Tipo de pregunta de OpenTDB: multiple (por defecto), boolean o any
+	tipo := r.URL.Query().Get("type")
+	if tipo == "" {
+		tipo = QuestionMultiple
+	}
+	url := AppConfig.OpenTDBURL + "?amount=10"
+	switch tipo {
+	case QuestionMultiple, QuestionBoolean:
+		url += "&type=" + tipo
+	case "any":
+	default:
+		writeError(w, r, http.StatusBadRequest, "QUESTION_TYPE_INVALID")
+		return
+	}
+
+	// Los fallos de OpenTDB se responden como 502: el problema no es del servidor ni del cliente
+	resp, err := http.Get(url)
 	if err != nil {
-		http.Error(w, "Error al obtener preguntas", http.StatusInternalServerError)
+		requestLogger(r).Error("Error al consultar OpenTDB", "error", err)
+		questionImportsTotal.Inc("fetch_error")
+		writeError(w, r, http.StatusBadGateway, "QUESTIONS_FETCH_FAILED")
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		requestLogger(r).Error("Respuesta inesperada de OpenTDB", "status", resp.StatusCode)
+		questionImportsTotal.Inc("fetch_error")
+		writeError(w, r, http.StatusBadGateway, "QUESTIONS_FETCH_FAILED")
+		return
+	}
 
-	body, _ := io.ReadAll(resp.Body)
 	var apiResp APIResponse
-	_ = json.Unmarshal(body, &apiResp)
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &apiResp)
+	}
+	if err != nil {
+		requestLogger(r).Error("Error al leer la respuesta de OpenTDB", "error", err)
+		questionImportsTotal.Inc("decode_error")
+		writeError(w, r, http.StatusBadGateway, "QUESTIONS_FETCH_FAILED")
+		return
+	}
+	if len(apiResp.Results) == 0 {
+		questionImportsTotal.Inc("empty")
+		_, _ = w.Write([]byte("OpenTDB no devolvió preguntas; no se guardó ninguna"))
+		return
+	}
 
 	categorias := map[string]string{
 		"General Knowledge": "Cultura general",
@@ -257,6 +420,16 @@ func FetchAndSaveQuestions(w http.ResponseWriter, r *http.Request) {
 		"hard":   "difícil",
 	}
 
+	// El lote y su entrada de auditoría se guardan juntos (o nada)
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		questionImportsTotal.Inc("save_error")
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+
+	var imported []int
 	for _, q := range apiResp.Results {
 		catTraducida := categorias[q.Category]
 		if catTraducida == "" {
@@ -267,40 +440,131 @@ func FetchAndSaveQuestions(w http.ResponseWriter, r *http.Request) {
 			difTraducida = q.Difficulty
 		}
 
-		if _, err := DB.Exec(`
-            INSERT INTO questions (question, correct_answer, incorrect_answers, categoria, dificultad)
-            VALUES ($1, $2, $3, $4, $5)`,
-			q.Question, q.CorrectAnswer, pq.Array(q.IncorrectAnswers), catTraducida, difTraducida); err != nil {
-			http.Error(w, "Error al guardar pregunta", http.StatusInternalServerError)
+		qType := QuestionMultiple
+		if q.Type == QuestionBoolean {
+			qType = QuestionBoolean
+		}
+
+		var id int
+		if err := tx.QueryRow(`
+            INSERT INTO questions (question, correct_answer, incorrect_answers, categoria, dificultad, type, source_lang)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING id`,
+			q.Question, q.CorrectAnswer, pq.Array(q.IncorrectAnswers), catTraducida, difTraducida, qType, defaultSourceLang).Scan(&id); err != nil {
+			questionImportsTotal.Inc("save_error")
+			writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
 			return
 		}
+		imported = append(imported, id)
+	}
+	if err := recordAudit(tx, r, auditQuestionsImport, auditTargetQuestion, nil, nil,
+		map[string]interface{}{"source": "opentdb", "type": tipo, "ids": imported}); err != nil {
+		questionImportsTotal.Inc("save_error")
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		questionImportsTotal.Inc("save_error")
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_SAVE_FAILED")
+		return
+	}
+	for range imported {
+		questionsImportedTotal.Inc()
 	}
+	questionImportsTotal.Inc("success")
 
 	_, _ = w.Write([]byte("Preguntas guardadas exitosamente con traducción al español"))
 }
 
 // Obtener preguntas guardadas con filtros
+//
+// Parámetros opcionales de selección aleatoria:
+//   - limit: número de preguntas a muestrear al azar
+//   - seed: semilla para obtener una muestra y orden reproducibles
+//   - exclude: "answered" o "correct" para omitir preguntas ya respondidas por el usuario (requiere token)
+//   - shuffle: "true" para devolver las opciones mezcladas en el campo options
+//   - lang: idioma de las preguntas (por defecto Accept-Language; si no hay traducción se usa el original)
 func GetQuestions(w http.ResponseWriter, r *http.Request) {
-	categoria := r.URL.Query().Get("categoria")
-	dificultad := r.URL.Query().Get("dificultad")
+	params := r.URL.Query()
+	categoria := params.Get("categoria")
+	dificultad := params.Get("dificultad")
 
-	query := "SELECT id, question, correct_answer, incorrect_answers FROM questions"
+	var conditions []string
 	var args []interface{}
-	if categoria != "" && dificultad != "" {
-		query += " WHERE categoria = $1 AND dificultad = $2"
-		args = append(args, categoria, dificultad)
-	} else if categoria != "" {
-		query += " WHERE categoria = $1"
+	if categoria != "" {
 		args = append(args, categoria)
-	} else if dificultad != "" {
-		query += " WHERE dificultad = $1"
+		conditions = append(conditions, fmt.Sprintf("categoria = $%d", len(args)))
+	}
+	if dificultad != "" {
 		args = append(args, dificultad)
+		conditions = append(conditions, fmt.Sprintf("dificultad = $%d", len(args)))
+	}
+
+	// Excluir preguntas que el usuario ya respondió (o acertó)
+	if exclude := params.Get("exclude"); exclude != "" {
+		if exclude != "answered" && exclude != "correct" {
+			writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "exclude")
+			return
+		}
+		userID, err := userIDFromRequest(r)
+		if err != nil {
+			writeError(w, r, http.StatusUnauthorized, "TOKEN_REQUIRED_FOR_EXCLUDE")
+			return
+		}
+		args = append(args, userID)
+		cond := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM attempts a WHERE a.question_id = questions.id AND a.user_id = $%d", len(args))
+		if exclude == "correct" {
+			cond += " AND a.is_correct"
+		}
+		conditions = append(conditions, cond+")")
+	}
+
+	limit := 0
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "limit")
+			return
+		}
+		limit = n
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seed, seeded := int64(0), false
+	if v := params.Get("seed"); v != "" {
+		var err error
+		seed, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "seed")
+			return
+		}
+		rng = rand.New(rand.NewSource(seed))
+		seeded = true
+	}
+
+	query := "SELECT id, question, correct_answer, incorrect_answers, " + gradingColumns + ", COALESCE(source_lang, 'en') FROM questions"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// La muestra se toma en la base de datos. Con semilla se ordena por un hash
+	// de id y semilla: la misma semilla da la misma muestra y el mismo orden
+	switch {
+	case seeded:
+		args = append(args, strconv.FormatInt(seed, 10))
+		query += fmt.Sprintf(" ORDER BY md5(id::text || ':' || $%d), id", len(args))
+	case limit > 0:
+		query += " ORDER BY random()"
+	default:
+		query += " ORDER BY id DESC"
+	}
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
 	}
-	query += " ORDER BY id DESC"
 
 	rows, err := DB.Query(query, args...)
 	if err != nil {
-		http.Error(w, "Error al obtener preguntas", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "QUESTIONS_FETCH_FAILED")
 		return
 	}
 	defer rows.Close()
@@ -308,52 +572,59 @@ func GetQuestions(w http.ResponseWriter, r *http.Request) {
 	var questions []Question
 	for rows.Next() {
 		var q Question
-		if err := rows.Scan(&q.ID, &q.Question, &q.CorrectAnswer, pq.Array(&q.IncorrectAnswers)); err != nil {
-			http.Error(w, "Error al procesar pregunta", http.StatusInternalServerError)
+		if err := rows.Scan(&q.ID, &q.Question, &q.CorrectAnswer, pq.Array(&q.IncorrectAnswers),
+			&q.Type, pq.Array(&q.CorrectAnswers), &q.Tolerance, &q.Lang); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "QUESTION_READ_FAILED")
 			return
 		}
 		questions = append(questions, q)
 	}
 
+	// Traducir al idioma solicitado (lang o Accept-Language) con respaldo al original
+	if err := applyTranslations(questions, requestLang(r)); err != nil {
+		requestLogger(r).Warn("No se pudieron aplicar traducciones", "error", err)
+	}
+	if params.Get("shuffle") == "true" {
+		for i := range questions {
+			questions[i].Options = shuffledOptions(questions[i], rng)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(questions)
 }
 
+// Opciones de la pregunta (correctas e incorrectas) en orden aleatorio; las
+// preguntas de texto libre o numéricas no tienen opciones
+
+func shuffledOptions(q Question, rng *rand.Rand) []string {
+	var options []string
+	switch q.Type {
+	case QuestionText, QuestionNumeric:
+		return nil
+	case QuestionMultiSelect:
+		options = append(append(options, q.CorrectAnswers...), q.IncorrectAnswers...)
+	default:
+		options = append([]string{q.CorrectAnswer}, q.IncorrectAnswers...)
+	}
+	rng.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+	return options
+}
+
 // Historial global (solo admin)
 
 func GetAttemptsAdmin(w http.ResponseWriter, r *http.Request) {
-	// Validar token en el header Authorization
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Token faltante", http.StatusUnauthorized)
-		return
-	}
-	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Verificar token y extraer claims
-	claims, err := VerifyToken(tokenStr)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
-	}
-
-	// Validar rol admin
-	role, ok := claims["role"].(string)
-	if !ok || role != "admin" {
-		http.Error(w, "No autorizado", http.StatusForbidden)
-		return
-	}
-
-	// Si el rol es admin, continuar con la consulta
+	// El rol admin (o el scope results:read de una clave de API) lo comprueba AuthMiddleware
 	rows, err := DB.Query(`
-		SELECT a.id, a.user_id, q.question, a.selected_answer, a.is_correct, a.answered_at, u.username
+		SELECT a.id, a.user_id, q.question, a.selected_answer, a.is_correct, a.answered_at, u.username,
+		       a.time_taken_ms, COALESCE(a.timed_out, false)
 		FROM attempts a
 		JOIN questions q ON a.question_id = q.id
 		JOIN users u ON a.user_id = u.id
 		ORDER BY a.answered_at DESC
 		LIMIT 50`)
 	if err != nil {
-		http.Error(w, "Error al obtener intentos", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPTS_FETCH_FAILED")
 		return
 	}
 	defer rows.Close()
@@ -362,8 +633,9 @@ func GetAttemptsAdmin(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var a AttemptView
 		var answeredAt time.Time
-		if err := rows.Scan(&a.ID, &a.UserID, &a.Question, &a.SelectedAnswer, &a.IsCorrect, &answeredAt, &a.Username); err != nil {
-			http.Error(w, "Error al procesar intento", http.StatusInternalServerError)
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Question, &a.SelectedAnswer, &a.IsCorrect, &answeredAt, &a.Username,
+			&a.TimeTakenMs, &a.TimedOut); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "ATTEMPT_READ_FAILED")
 			return
 		}
 		a.AnsweredAt = answeredAt.Format(time.RFC3339)
@@ -374,10 +646,34 @@ func GetAttemptsAdmin(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(attempts)
 }
 
+// Listar usuarios. Los eliminados se omiten salvo con ?deleted=include (todos)
+// o ?deleted=only; ?suspended=true deja solo los suspendidos.
+
 func GetUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := DB.Query(`SELECT id, email, username, role FROM users ORDER BY id`)
+	q := r.URL.Query()
+	where := []string{}
+	switch q.Get("deleted") {
+	case "", "exclude":
+		where = append(where, "deleted_at IS NULL")
+	case "only":
+		where = append(where, "deleted_at IS NOT NULL")
+	case "include":
+	default:
+		writeFieldError(w, r, http.StatusBadRequest, "INVALID_REQUEST", "deleted")
+		return
+	}
+	if q.Get("suspended") == "true" {
+		where = append(where, suspensionActiveSQL)
+	}
+	query := `SELECT id, email, username, role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL,
+		deleted_at, erased_at, ` + suspensionActiveSQL + `, suspended_at, suspended_until, COALESCE(suspension_reason, ''), suspended_by
+		FROM users`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := DB.Query(query + " ORDER BY id")
 	if err != nil {
-		http.Error(w, "Error al obtener usuarios", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "USERS_FETCH_FAILED")
 		return
 	}
 	defer rows.Close()
@@ -385,10 +681,23 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.Role); err != nil {
-			http.Error(w, "Error al procesar usuario", http.StatusInternalServerError)
+		var deletedAt, erasedAt, suspendedAt, suspendedUntil sql.NullTime
+		var suspended bool
+		var reason string
+		var suspendedBy sql.NullInt64
+		if err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.Role, &u.EmailVerified, &u.TwoFactorEnabled,
+			&deletedAt, &erasedAt, &suspended, &suspendedAt, &suspendedUntil, &reason, &suspendedBy); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
 			return
 		}
+		u.DeletedAt, u.ErasedAt = nullTimePtr(deletedAt), nullTimePtr(erasedAt)
+		if suspended {
+			u.Suspension = &Suspension{Reason: reason, Since: suspendedAt.Time, Until: nullTimePtr(suspendedUntil)}
+			if suspendedBy.Valid {
+				by := int(suspendedBy.Int64)
+				u.Suspension.By = &by
+			}
+		}
 		users = append(users, u)
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -398,23 +707,59 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 func CreateUserAdmin(w http.ResponseWriter, r *http.Request) {
 	var u User
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
 		return
 	}
 	if u.Email == "" || u.Username == "" || u.Password == "" || u.Role == "" {
-		http.Error(w, "Email, username, password y role son requeridos", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "USER_FIELDS_REQUIRED")
+		return
+	}
+	if !validRole(u.Role) {
+		writeFieldError(w, r, http.StatusBadRequest, "ROLE_INVALID", "role")
+		return
+	}
+	u.Email = strings.TrimSpace(u.Email)
+	if !validEmail(u.Email) {
+		writeFieldError(w, r, http.StatusBadRequest, "EMAIL_INVALID", "email")
 		return
 	}
-	hashed, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	u.Username = strings.TrimSpace(u.Username)
+	if !checkUsernameAvailable(w, r, u.Username, 0) {
+		return
+	}
+	if code := checkPassword(u.Password, u.Email, u.Username); code != "" {
+		writeFieldError(w, r, http.StatusBadRequest, code, "password")
+		return
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(u.Password), AppConfig.BcryptCost)
 	if err != nil {
-		http.Error(w, "Error al encriptar contraseña", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_HASH_FAILED")
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_CREATE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRow(`INSERT INTO users (email, username, password, role) VALUES ($1, $2, $3, $4) RETURNING id`,
+		u.Email, u.Username, string(hashed), u.Role).Scan(&u.ID); err != nil {
+		requestLogger(r).Error("Error al crear usuario admin", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_CREATE_FAILED")
+		return
+	}
+	// Sin email ni username: la auditoría no guarda datos personales
+	if err := recordAudit(tx, r, auditUserCreate, auditTargetUser, u.ID, nil, map[string]string{"role": u.Role}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
 		return
 	}
-	if _, err := DB.Exec(`INSERT INTO users (email, username, password, role) VALUES ($1, $2, $3, $4)`, u.Email, u.Username, string(hashed), u.Role); err != nil {
-		log.Println("❌ Error al crear usuario admin:", err)
-		http.Error(w, "Error al crear usuario", http.StatusInternalServerError)
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_CREATE_FAILED")
 		return
 	}
+	if err := sendVerificationEmail(r, u.ID, u.Email); err != nil {
+		requestLogger(r).Error("Error al enviar correo de verificación", "error", err)
+	}
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Usuario creado"})
 }
@@ -423,58 +768,139 @@ func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
 	// Expecting URL: /admin/users/{id}
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 4 {
-		http.Error(w, "ID de usuario faltante", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "USER_ID_MISSING")
+		return
+	}
+	id, err := strconv.Atoi(parts[3])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
 		return
 	}
-	id := parts[3]
 	var body struct {
 		Role string `json:"role"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
 		return
 	}
 	if body.Role == "" {
-		http.Error(w, "Role requerido", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "ROLE_REQUIRED")
+		return
+	}
+	if !validRole(body.Role) {
+		writeFieldError(w, r, http.StatusBadRequest, "ROLE_INVALID", "role")
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "ROLE_UPDATE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	// Como al eliminar o suspender, no se puede dejar el sistema sin admins activos
+	if body.Role != "admin" {
+		last, err := isLastActiveAdminTx(tx, id)
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "ROLE_UPDATE_FAILED")
+			return
+		}
+		if last {
+			writeError(w, r, http.StatusConflict, "LAST_ADMIN")
+			return
+		}
+	}
+	// old es la fila antes del UPDATE, para registrar el rol anterior
+	var oldRole string
+	err = tx.QueryRow(`
+		UPDATE users u SET role = $2 FROM users old
+		WHERE old.id = u.id AND u.id = $1
+		RETURNING old.role`, id, body.Role).Scan(&oldRole)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error al actualizar rol", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "ROLE_UPDATE_FAILED")
 		return
 	}
-	if _, err := DB.Exec(`UPDATE users SET role = $2 WHERE id = $1`, id, body.Role); err != nil {
-		log.Println("❌ Error al actualizar rol:", err)
-		http.Error(w, "Error al actualizar rol", http.StatusInternalServerError)
+	if err := recordAudit(tx, r, auditUserRoleUpdate, auditTargetUser, id,
+		map[string]string{"role": oldRole}, map[string]string{"role": body.Role}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "ROLE_UPDATE_FAILED")
 		return
 	}
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Rol actualizado"})
 }
 
+// Eliminar un usuario (borrado lógico; ver PurgeUser para el borrado definitivo)
+
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
-		http.Error(w, "ID de usuario faltante", http.StatusBadRequest)
+	id, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	last, err := isLastActiveAdminTx(tx, id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
 		return
 	}
-	id := parts[3]
-	if _, err := DB.Exec(`DELETE FROM users WHERE id = $1`, id); err != nil {
-		log.Println("❌ Error al eliminar usuario:", err)
-		http.Error(w, "Error al eliminar usuario", http.StatusInternalServerError)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if last {
+		writeError(w, r, http.StatusConflict, "LAST_ADMIN")
+		return
+	}
+	var deletedAt time.Time
+	err = tx.QueryRow(`UPDATE users SET deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP) WHERE id = $1 RETURNING deleted_at`, id).
+		Scan(&deletedAt)
+	if err != nil {
+		requestLogger(r).Error("Error al eliminar usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
 		return
 	}
+	if err := recordAudit(tx, r, auditUserDelete, auditTargetUser, id, nil, map[string]interface{}{"deletedAt": deletedAt}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
+		return
+	}
+	requestLogger(r).Info("Usuario eliminado", "target_user_id", id)
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Usuario eliminado"})
 }
+
 func GetUserSummary(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Token faltante", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_MISSING")
 		return
 	}
 	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 	claims, err := VerifyToken(tokenStr)
 	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
 		return
 	}
 	userID, ok := claims["user"].(float64)
 	if !ok {
-		http.Error(w, "ID inválido", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "USER_ID_INVALID")
 		return
 	}
 
@@ -485,7 +911,7 @@ func GetUserSummary(w http.ResponseWriter, r *http.Request) {
         ORDER BY created_at DESC
         LIMIT 10`, int(userID))
 	if err != nil {
-		http.Error(w, "Error al obtener resumen", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "SUMMARY_FETCH_FAILED")
 		return
 	}
 	defer rows.Close()
@@ -495,7 +921,7 @@ func GetUserSummary(w http.ResponseWriter, r *http.Request) {
 		var correct, incorrect int
 		var createdAt time.Time
 		if err := rows.Scan(&correct, &incorrect, &createdAt); err != nil {
-			http.Error(w, "Error al procesar resumen", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "SUMMARY_READ_FAILED")
 			return
 		}
 		summaries = append(summaries, map[string]interface{}{
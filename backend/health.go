@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Versión de la aplicación; se puede fijar al compilar con
+// go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// Tiempo máximo de cada comprobación de /readyz
+const readyCheckTimeout = 2 * time.Second
+
+// Liveness: el proceso está vivo y atiende solicitudes
+
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readiness: base de datos accesible, tablas migradas y, opcionalmente,
+// OpenTDB accesible (READY_CHECK_OPENTDB o ?opentdb=true)
+
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+
+	if err := DB.PingContext(ctx); err != nil {
+		requestLogger(r).Warn("/readyz: base de datos no disponible", "error", err)
+		checks["database"] = "error"
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	if schemaReady.Load() {
+		checks["migrations"] = "ok"
+	} else {
+		checks["migrations"] = "pending"
+		ready = false
+	}
+
+	if AppConfig.ReadyCheckOpenTDB || r.URL.Query().Get("opentdb") == "true" {
+		if err := pingOpenTDB(ctx); err != nil {
+			requestLogger(r).Warn("/readyz: OpenTDB no disponible", "error", err)
+			checks["opentdb"] = "error"
+			ready = false
+		} else {
+			checks["opentdb"] = "ok"
+		}
+	}
+
+	status := "ok"
+	code := http.StatusOK
+	if !ready {
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func pingOpenTDB(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, AppConfig.OpenTDBURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Información de compilación
+
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	info := map[string]string{"version": version}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["goVersion"] = bi.GoVersion
+		info["module"] = bi.Main.Path
+		if version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info["version"] = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info["commit"] = s.Value
+			case "vcs.time":
+				info["commitTime"] = s.Value
+			case "vcs.modified":
+				info["modified"] = s.Value
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
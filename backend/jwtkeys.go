@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claves de los JWT de sesión. Con JWT_KEYS_DIR se firma con RS256 (claves RSA)
+// o EdDSA (Ed25519): cada archivo .pem del directorio es una clave cuyo kid es
+// el nombre del archivo. Firma la de JWT_SIGNING_KID (o la última por nombre) y
+// las demás solo verifican, para poder rotar sin invalidar las sesiones abiertas.
+// Las claves públicas se publican en /.well-known/jwks.json. Sin JWT_KEYS_DIR
+// se mantiene HS256 con JWT_SECRET.
+
+type jwtKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Clave para firmar (nil si solo verifica) y para verificar
+	Signing   interface{}
+	Verifying interface{}
+}
+
+type jwtKeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// Juego de claves activo; se reemplaza entero al recargar (SIGHUP)
+var jwtKeys atomic.Pointer[jwtKeySet]
+
+func (s *jwtKeySet) lookup(kid string) (*jwtKey, bool) {
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// Algoritmos de las claves cargadas; son los únicos que acepta VerifyToken
+
+func (s *jwtKeySet) algorithms() []string {
+	var algs []string
+	for _, k := range s.keys {
+		if alg := k.Method.Alg(); !containsString(algs, alg) {
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// Sin JWT_KEYS_DIR se firma con HS256; Config.Validate lo rechaza en producción
+
+func loadJWTKeys(cfg Config) (*jwtKeySet, error) {
+	if cfg.JWTKeysDir == "" {
+		k := &jwtKey{ID: "hs256", Method: jwt.SigningMethodHS256, Signing: []byte(cfg.JWTSecret), Verifying: []byte(cfg.JWTSecret)}
+		return &jwtKeySet{signing: k, keys: map[string]*jwtKey{k.ID: k}}, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.JWTKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 && !cfg.IsProduction() {
+		// En desarrollo se crea una clave para no tener que generarla a mano
+		path, err := generateJWTKey(cfg.JWTKeysDir)
+		if err != nil {
+			return nil, err
+		}
+		slog.Warn("JWT_KEYS_DIR vacío: generada una clave Ed25519 (solo para desarrollo)", "path", path)
+		paths = []string{path}
+	}
+	sort.Strings(paths)
+
+	set := &jwtKeySet{keys: map[string]*jwtKey{}}
+	for _, path := range paths {
+		k, err := readJWTKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		set.keys[k.ID] = k
+		if k.Signing != nil && (cfg.JWTSigningKID == "" || cfg.JWTSigningKID == k.ID) {
+			set.signing = k
+		}
+	}
+	if set.signing == nil {
+		if cfg.JWTSigningKID != "" {
+			return nil, fmt.Errorf("JWT_SIGNING_KID %q no corresponde a ninguna clave privada de JWT_KEYS_DIR", cfg.JWTSigningKID)
+		}
+		return nil, errors.New("JWT_KEYS_DIR no contiene ninguna clave privada")
+	}
+	return set, nil
+}
+
+// Leer una clave PEM: privada (PKCS#8 o PKCS#1) o pública (PKIX, solo verificación)
+
+func readJWTKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no es un archivo PEM")
+	}
+	k := &jwtKey{ID: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("tipo PEM %q no admitido", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.Method, k.Signing, k.Verifying = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Method, k.Verifying = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.Method, k.Signing, k.Verifying = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Method, k.Verifying = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("tipo de clave %T no admitido (usa RSA o Ed25519)", parsed)
+	}
+	if pub, ok := k.Verifying.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return nil, errors.New("las claves RSA deben tener al menos 2048 bits")
+	}
+	return k, nil
+}
+
+func generateJWTKey(dir string) (string, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405")+".pem")
+	return path, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// Recargar JWT_KEYS_DIR al recibir SIGHUP (rotación sin reiniciar); si la
+// recarga falla se siguen usando las claves anteriores
+
+func watchJWTKeyReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		set, err := loadJWTKeys(AppConfig)
+		if err != nil {
+			slog.Error("No se pudieron recargar las claves JWT", "error", err)
+			continue
+		}
+		jwtKeys.Store(set)
+		slog.Info("Claves JWT recargadas", "signing_kid", set.signing.ID, "keys", len(set.keys))
+	}
+}
+
+// Claves públicas en formato JWK (RFC 7517 / RFC 8037)
+
+func publicJWK(k *jwtKey) (map[string]string, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := map[string]string{"kid": k.ID, "use": "sig", "alg": k.Method.Alg()}
+	switch pub := k.Verifying.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = b64(pub.N.Bytes())
+		jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = b64(pub)
+	default:
+		// Las claves HMAC nunca se publican
+		return nil, false
+	}
+	return jwk, true
+}
+
+// Publicar las claves de verificación para que otros servicios validen nuestros JWT
+
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	set := jwtKeys.Load()
+	keys := []map[string]string{}
+	ids := make([]string, 0, len(set.keys))
+	for id := range set.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if jwk, ok := publicJWK(set.keys[id]); ok {
+			keys = append(keys, jwk)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(body)
+}
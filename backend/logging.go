@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Cabecera con el identificador de la solicitud (se acepta la del cliente o proxy)
+const requestIDHeader = "X-Request-ID"
+
+// Rutas de sondas y métricas: su registro de acceso va a nivel debug para no inundar los logs
+var quietRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// Claves cuyo valor nunca se escribe en los logs
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie", "api_key", "apikey"}
+
+// Logger global en JSON (o texto) con el nivel de LOG_LEVEL
+
+func setupLogger(cfg Config) {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel, ReplaceAttr: redactAttr}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if cfg.LogFormat == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	// slog.SetDefault también redirige el paquete log a este handler
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(v string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(v))
+	return level, err
+}
+
+// Ocultar los valores de atributos sensibles (también dentro de grupos)
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Datos de la solicitud compartidos entre middlewares; AuthMiddleware
+// completa el usuario o la clave de API para el registro de acceso
+
+type requestInfo struct {
+	ID     string
+	UserID int
+	KeyID  int // clave de API usada, si la hay
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// Logger con el ID de la solicitud
+
+func requestLogger(r *http.Request) *slog.Logger {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return slog.Default().With("request_id", info.ID)
+	}
+	return slog.Default()
+}
+
+// Un ID recibido solo se reutiliza si es corto y con caracteres seguros,
+// para que no se puedan inyectar líneas o datos arbitrarios en los logs
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware que asigna X-Request-ID y escribe el registro de acceso
+
+func requestLogMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		info := &requestInfo{ID: id}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		route := r.URL.Path
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case quietRoutes[route]:
+			level = slog.LevelDebug
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if info.UserID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.UserID))
+		}
+		if info.KeyID != 0 {
+			attrs = append(attrs, slog.Int("key_id", info.KeyID))
+		}
+		slog.LogAttrs(r.Context(), level, "solicitud HTTP", attrs...)
+	})
+}
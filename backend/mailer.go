@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Correo saliente
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Envío de correos; para producción se puede implementar sobre SMTP o un
+// proveedor externo sin cambiar los handlers
+
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+// Mailer activo; se asigna desde MAILER al iniciar
+var mailer Mailer = logMailer{}
+
+// Tiempo máximo de un envío en segundo plano
+const mailSendTimeout = 30 * time.Second
+
+// Enviar un correo sin esperar al resultado; los errores solo se registran
+
+func sendMailAsync(r *http.Request, msg MailMessage, errMsg string) {
+	logger := requestLogger(r)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			logger.Error(errMsg, "error", err)
+		}
+	}()
+}
+
+func newMailer(cfg Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case "log":
+		if cfg.IsProduction() {
+			slog.Warn("MAILER=log escribe los correos (y sus enlaces) en los logs; no usar en producción")
+		}
+		return logMailer{}, nil
+	case "file":
+		if err := os.MkdirAll(cfg.MailDir, 0o700); err != nil {
+			return nil, fmt.Errorf("no se pudo crear MAIL_DIR: %w", err)
+		}
+		return fileMailer{dir: cfg.MailDir, from: cfg.MailFrom}, nil
+	}
+	return nil, fmt.Errorf("MAILER desconocido %q", cfg.Mailer)
+}
+
+// Escribe los correos en el log (desarrollo)
+
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, msg MailMessage) error {
+	slog.InfoContext(ctx, "Correo enviado (log)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// Guarda cada correo como archivo .eml en un directorio (desarrollo y pruebas)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m fileMailer) Send(ctx context.Context, msg MailMessage) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitizeFileName(msg.To))
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		headerValue(m.from), headerValue(msg.To), headerValue(msg.Subject), time.Now().Format(time.RFC1123Z), msg.Body)
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o600)
+}
+
+// Evitar que un valor inserte cabeceras adicionales
+var headerValue = strings.NewReplacer("\r", "", "\n", "").Replace
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -1,54 +1,129 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("Configuración inválida", "error", err)
+		os.Exit(1)
+	}
+	AppConfig = cfg
+	setupLogger(AppConfig)
+	AppConfig.warnDefaults()
+	keys, err := loadJWTKeys(AppConfig)
+	if err != nil {
+		slog.Error("Claves JWT inválidas", "error", err)
+		os.Exit(1)
+	}
+	jwtKeys.Store(keys)
+	go watchJWTKeyReload()
+
+	if mailer, err = newMailer(AppConfig); err != nil {
+		slog.Error("Configuración de correo inválida", "error", err)
+		os.Exit(1)
+	}
+
+	// La conexión se reintenta en segundo plano para que /healthz responda
+	// mientras la base de datos aún no está disponible
 	InitDB()
+	go func() {
+		if err := ConnectDB(context.Background()); err != nil {
+			slog.Error("No se pudo iniciar la base de datos", "error", err)
+			os.Exit(1)
+		}
+	}()
 
 	r := mux.NewRouter()
 
-	// Middleware CORS + Authorization
-	r.Use(func(h http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			origin := r.Header.Get("Origin")
-			if origin == "" {
-				origin = "*"
-			}
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Vary", "Origin")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Max-Age", "86400")
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			h.ServeHTTP(w, r)
-		})
-	})
+	// ID de solicitud y registro de acceso
+	r.Use(requestLogMiddleware)
+
+	// Métricas de cada solicitud (antes de CORS para medir también los preflights)
+	r.Use(metricsMiddleware)
+
+	// Middleware CORS con lista de orígenes permitidos
+	corsDefault, corsRoutes := corsPolicies(AppConfig)
+	r.Use(corsMiddleware(corsDefault, corsRoutes))
+
+	// Sondas para el orquestador
+	r.HandleFunc("/healthz", HealthHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/readyz", ReadyHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/version", VersionHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/metrics", MetricsHandler).Methods("GET", "OPTIONS")
 
 	//  Rutas públicas
-	r.HandleFunc("/register", RegisterHandler).Methods("POST", "OPTIONS")
-	r.HandleFunc("/login", LoginHandler).Methods("POST", "OPTIONS")
-	r.HandleFunc("/questions/fetch", FetchAndSaveQuestions).Methods("GET", "OPTIONS")
+	r.HandleFunc("/register", RateLimitMiddleware(RegisterHandler, "register", AppConfig.RateLimitRegister)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/login", RateLimitMiddleware(LoginHandler, "login", AppConfig.RateLimitLogin)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/login/2fa", RateLimitMiddleware(LoginSecondFactor, "login", AppConfig.RateLimitLogin)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/.well-known/jwks.json", JWKSHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/auth/oidc", GetOIDCInfo).Methods("GET", "OPTIONS")
+	r.HandleFunc("/auth/oidc/login", RateLimitMiddleware(OIDCLogin, "login", AppConfig.RateLimitLogin)).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", RateLimitMiddleware(OIDCCallback, "login", AppConfig.RateLimitLogin)).Methods("GET")
+	r.HandleFunc("/verify-email", VerifyEmail).Methods("GET", "POST", "OPTIONS")
+	r.HandleFunc("/verify-email/resend", RateLimitMiddleware(ResendVerificationEmail, "verify_email", AppConfig.RateLimitVerifyEmail)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/password/policy", GetPasswordPolicy).Methods("GET", "OPTIONS")
+	r.HandleFunc("/password/forgot", RateLimitMiddleware(ForgotPassword, "password_forgot", AppConfig.RateLimitPasswordReset)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/password/reset", RateLimitMiddleware(ResetPassword, "password_reset", AppConfig.RateLimitPasswordReset)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/questions", GetQuestions).Methods("GET", "OPTIONS")
-	r.HandleFunc("/attempts/answers", SaveAttemptAnswers).Methods("POST", "OPTIONS")
 	r.HandleFunc("/user/resumen", AuthMiddleware(GetUserSummary, "user")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/me", AuthMiddleware(GetMyProfile, "")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/me", AuthMiddleware(UpdateMyProfile, "")).Methods("PATCH", "OPTIONS")
+	r.HandleFunc("/user/me", AuthMiddleware(DeleteMyAccount, "")).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/user/me/erase", AuthMiddleware(EraseMyAccount, "")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user/export", AuthMiddleware(ExportMyData, "")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/password", AuthMiddleware(ChangePassword, "")).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/user/2fa", AuthMiddleware(GetTwoFactorStatus, "")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/2fa/setup", AuthMiddleware(SetupTwoFactor, "")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user/2fa/enable", AuthMiddleware(EnableTwoFactor, "")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user/2fa/disable", AuthMiddleware(DisableTwoFactor, "")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user/2fa/recovery-codes", AuthMiddleware(RegenerateRecoveryCodes, "")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user/stats", AuthMiddleware(GetUserStats, "user")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/review", AuthMiddleware(RequireVerifiedEmail(GetReviewQuestions, restrictQuiz), "user")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user/review", AuthMiddleware(RequireVerifiedEmail(GradeReviewAnswers, restrictQuiz), "user")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/quiz/sessions", AuthMiddleware(RequireVerifiedEmail(StartQuizSession, restrictQuiz), "user")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/quiz/sessions/{id}/answers", AuthMiddleware(RequireVerifiedEmail(AnswerQuizSession, restrictQuiz), "user")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/quiz/sessions/{id}/finish", AuthMiddleware(RequireVerifiedEmail(FinishQuizSession, restrictQuiz), "user")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/quiz/adaptive/next", AuthMiddleware(RequireVerifiedEmail(GetAdaptiveQuestion, restrictQuiz), "user")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/quiz/adaptive/answer", AuthMiddleware(RequireVerifiedEmail(AnswerAdaptiveQuestion, restrictQuiz), "user")).Methods("POST", "OPTIONS")
 
-	r.HandleFunc("/admin/users", AuthMiddleware(GetUsers, "admin")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users", AuthMiddleware(GetUsers, "admin", scopeUsersRead)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/admin/users", AuthMiddleware(CreateUserAdmin, "admin")).Methods("POST", "OPTIONS")
 	r.HandleFunc("/admin/users/{id}", AuthMiddleware(UpdateUserRole, "admin")).Methods("PUT", "PATCH", "OPTIONS")
 	r.HandleFunc("/admin/users/{id}", AuthMiddleware(DeleteUser, "admin")).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/users/{id}/restore", AuthMiddleware(RestoreUser, "admin")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/users/{id}/purge", AuthMiddleware(PurgeUser, "admin")).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/users/{id}/erase", AuthMiddleware(EraseUser, "admin")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/users/{id}/suspension", AuthMiddleware(SuspendUser, "admin")).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/admin/users/{id}/suspension", AuthMiddleware(UnsuspendUser, "admin")).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/audit", AuthMiddleware(GetAuditLog, "admin")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/api-keys", AuthMiddleware(ListAPIKeys, "admin")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/api-keys", AuthMiddleware(CreateAPIKey, "admin")).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/api-keys/{id}", AuthMiddleware(RevokeAPIKey, "admin")).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/questions", AuthMiddleware(CreateQuestionAdmin, "admin", scopeQuestionsWrite)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/questions/fetch", RateLimitMiddleware(AuthMiddleware(FetchAndSaveQuestions, "admin", scopeQuestionsWrite), "import", AppConfig.RateLimitImport)).Methods("GET")
+	r.HandleFunc("/admin/questions/{id}/translations", AuthMiddleware(GetQuestionTranslations, "admin", scopeQuestionsRead)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/questions/{id}/translations/{lang}", AuthMiddleware(UpsertQuestionTranslation, "admin", scopeQuestionsWrite)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/admin/questions/{id}/translations/{lang}", AuthMiddleware(DeleteQuestionTranslation, "admin", scopeQuestionsWrite)).Methods("DELETE", "OPTIONS")
 
 	//  Rutas protegidas
-	r.HandleFunc("/admin/historial", AuthMiddleware(GetAttemptsAdmin, "admin")).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/historial", AuthMiddleware(GetAttemptsAdmin, "admin", scopeResultsRead)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/user/historial", AuthMiddleware(GetUserAttempts, "user")).Methods("GET", "OPTIONS") // ✅ nueva
+	r.HandleFunc("/attempts/answers", AuthMiddleware(RequireVerifiedEmail(SaveAttemptAnswers, restrictQuiz), "user")).Methods("POST", "OPTIONS")
 
-	log.Println("✅ Servidor corriendo en http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if err := runServer(r); err != nil {
+		slog.Error("Error del servidor", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Métricas en formato de texto de Prometheus (versión 0.0.4), sin dependencias externas
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var metricsRegistry []collector
+
+func register[C collector](c C) C {
+	metricsRegistry = append(metricsRegistry, c)
+	return c
+}
+
+// Intervalos por defecto de Prometheus para latencias en segundos
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	httpRequestsTotal = register(newCounterVec("quizforge_http_requests_total",
+		"Solicitudes HTTP atendidas por método, ruta y estado.", "method", "route", "status"))
+	httpRequestDuration = register(newHistogramVec("quizforge_http_request_duration_seconds",
+		"Latencia de las solicitudes HTTP por método y ruta.", defaultBuckets, "method", "route"))
+
+	questionImportsTotal = register(newCounterVec("quizforge_question_imports_total",
+		"Importaciones de preguntas desde OpenTDB por resultado.", "outcome"))
+	questionsImportedTotal = register(newCounterVec("quizforge_questions_imported_total",
+		"Preguntas guardadas desde OpenTDB."))
+
+	quizzesCompletedTotal = register(newCounterVec("quizforge_quizzes_completed_total",
+		"Quizzes completados por origen (lote de respuestas o sesión).", "source"))
+	answersRecordedTotal = register(newCounterVec("quizforge_answers_recorded_total",
+		"Respuestas registradas por origen y resultado.", "source", "result"))
+	registrationsTotal = register(newCounterVec("quizforge_registrations_total",
+		"Usuarios registrados desde /register."))
+	rateLimitedTotal = register(newCounterVec("quizforge_rate_limited_total",
+		"Solicitudes rechazadas con 429 por limitador.", "limiter"))
+
+	_ = register(dbStatsCollector{})
+)
+
+// Registrar una respuesta guardada (source: batch, session, review o adaptive)
+
+func recordAnswer(source string, correct bool) {
+	result := "incorrect"
+	if correct {
+		result = "correct"
+	}
+	answersRecordedTotal.Inc(source, result)
+}
+
+// Contador con etiquetas
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]*sample{}}
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("métrica %s: se esperaban %d etiquetas", c.name, len(c.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labelValues: labelValues}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		// Los contadores sin etiquetas se exponen desde cero
+		writeSample(w, c.name, nil, nil, 0)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		writeSample(w, c.name, c.labels, s.labelValues, s.value)
+	}
+}
+
+// Histograma con etiquetas
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramSample
+}
+
+type histogramSample struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogramSample{}}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("métrica %s: se esperaban %d etiquetas", h.name, len(h.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogramSample{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		for i, upper := range h.buckets {
+			writeSample(w, h.name+"_bucket", labels, append(append([]string{}, s.labelValues...), formatFloat(upper)), float64(s.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", labels, append(append([]string{}, s.labelValues...), "+Inf"), float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labelValues, s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// Estadísticas del pool de conexiones, leídas en cada consulta de /metrics
+
+type dbStatsCollector struct{}
+
+func (dbStatsCollector) write(w io.Writer) {
+	if DB == nil {
+		return
+	}
+	st := DB.Stats()
+	metrics := []struct {
+		name, help, kind string
+		value            float64
+	}{
+		{"quizforge_db_max_open_connections", "Máximo de conexiones abiertas permitidas.", "gauge", float64(st.MaxOpenConnections)},
+		{"quizforge_db_open_connections", "Conexiones abiertas (en uso y ociosas).", "gauge", float64(st.OpenConnections)},
+		{"quizforge_db_in_use_connections", "Conexiones en uso.", "gauge", float64(st.InUse)},
+		{"quizforge_db_idle_connections", "Conexiones ociosas.", "gauge", float64(st.Idle)},
+		{"quizforge_db_wait_count_total", "Esperas por una conexión libre.", "counter", float64(st.WaitCount)},
+		{"quizforge_db_wait_duration_seconds_total", "Tiempo total esperando conexiones libres.", "counter", st.WaitDuration.Seconds()},
+		{"quizforge_db_max_idle_closed_total", "Conexiones cerradas por exceder el máximo de ociosas.", "counter", float64(st.MaxIdleClosed)},
+		{"quizforge_db_max_idle_time_closed_total", "Conexiones cerradas por tiempo ocioso.", "counter", float64(st.MaxIdleTimeClosed)},
+		{"quizforge_db_max_lifetime_closed_total", "Conexiones cerradas por tiempo de vida.", "counter", float64(st.MaxLifetimeClosed)},
+	}
+	for _, m := range metrics {
+		writeHeader(w, m.name, m.help, m.kind)
+		writeSample(w, m.name, nil, nil, m.value)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels, labelValues []string, value float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(labelValues[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(value))
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Exponer las métricas
+
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range metricsRegistry {
+		c.write(w)
+	}
+}
+
+// Middleware que mide cada solicitud. Se usa la plantilla de la ruta
+// (/quiz/sessions/{id}/answers) para no crear una serie por cada ID.
+
+func metricsMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "desconocida"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		httpRequestsTotal.Inc(r.Method, route, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// ResponseWriter que recuerda el código de estado enviado
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -1,12 +1,19 @@
 package main
 
+import "time"
 
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Role     string `json:"role"` 
+	ID               int    `json:"id"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	Password         string `json:"password"`
+	Role             string `json:"role"`
+	EmailVerified    bool   `json:"emailVerified"`
+	TwoFactorEnabled bool   `json:"twoFactorEnabled"`
+	// Solo en los listados de administración
+	DeletedAt  *time.Time  `json:"deletedAt,omitempty"`
+	ErasedAt   *time.Time  `json:"erasedAt,omitempty"`
+	Suspension *Suspension `json:"suspension,omitempty"`
 }
 
 
@@ -15,15 +22,23 @@ type Question struct {
 	Question         string   `json:"question"`
 	CorrectAnswer    string   `json:"correct_answer"`
 	IncorrectAnswers []string `json:"incorrect_answers"`
+	Options          []string `json:"options,omitempty"`
+	Type             string   `json:"type"`
+	CorrectAnswers   []string `json:"correct_answers,omitempty"`
+	Tolerance        *float64 `json:"tolerance,omitempty"`
+	Categoria        string   `json:"categoria,omitempty"`
+	Dificultad       string   `json:"dificultad,omitempty"`
+	Lang             string   `json:"lang,omitempty"`
 }
 
 
 
 type AttemptAnswer struct {
-	UserID         int    `json:"userId"`
-	QuestionID     int    `json:"questionId"`
-	SelectedAnswer string `json:"selectedAnswer"`
-	IsCorrect      bool   `json:"isCorrect"`
+	UserID          int      `json:"userId"`
+	QuestionID      int      `json:"questionId"`
+	SelectedAnswer  string   `json:"selectedAnswer"`
+	SelectedAnswers []string `json:"selectedAnswers,omitempty"`
+	IsCorrect       bool     `json:"isCorrect"`
 }
 
 type AttemptView struct {
@@ -34,6 +49,8 @@ type AttemptView struct {
 	IsCorrect      bool   `json:"isCorrect"`
 	AnsweredAt     string `json:"answeredAt"`
 	Username       string `json:"username"`
+	TimeTakenMs    *int64 `json:"timeTakenMs,omitempty"`
+	TimedOut       bool   `json:"timedOut"`
 }
 
 type APIResponse struct {
@@ -41,7 +58,111 @@ type APIResponse struct {
 		Question         string   `json:"question"`
 		CorrectAnswer    string   `json:"correct_answer"`
 		IncorrectAnswers []string `json:"incorrect_answers"`
+		Type             string   `json:"type"`
 		Category         string   `json:"category"`
 		Difficulty       string   `json:"difficulty"`
 	} `json:"results"`
 }
+
+type AccuracyStat struct {
+	Name     string  `json:"name"`
+	Total    int     `json:"total"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+type TrendPoint struct {
+	Period   string  `json:"period"`
+	Total    int     `json:"total"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+type UserStats struct {
+	UserID            int            `json:"userId"`
+	TotalAnswered     int            `json:"totalAnswered"`
+	TotalCorrect      int            `json:"totalCorrect"`
+	Accuracy          float64        `json:"accuracy"`
+	CurrentStreak     int            `json:"currentStreak"`
+	BestStreak        int            `json:"bestStreak"`
+	ByCategory        []AccuracyStat `json:"byCategory"`
+	ByDifficulty      []AccuracyStat `json:"byDifficulty"`
+	Trend             []TrendPoint   `json:"trend"`
+	WeakestCategories []AccuracyStat `json:"weakestCategories"`
+}
+
+// Pregunta de repaso sin la respuesta correcta: solo el enunciado y las
+// opciones mezcladas (la corrección la hace POST /user/review)
+type ReviewItem struct {
+	ID           int      `json:"id"`
+	Question     string   `json:"question"`
+	Type         string   `json:"type"`
+	Options      []string `json:"options,omitempty"`
+	Lang         string   `json:"lang,omitempty"`
+	Repetitions  int      `json:"repetitions"`
+	IntervalDays int      `json:"intervalDays"`
+	EaseFactor   float64  `json:"easeFactor"`
+	DueAt        string   `json:"dueAt"`
+}
+
+type ReviewGrade struct {
+	QuestionID      int      `json:"questionId"`
+	SelectedAnswer  string   `json:"selectedAnswer"`
+	SelectedAnswers []string `json:"selectedAnswers,omitempty"`
+	Quality         *int     `json:"quality,omitempty"`
+}
+
+type ReviewResult struct {
+	QuestionID   int     `json:"questionId"`
+	IsCorrect    bool    `json:"isCorrect"`
+	Repetitions  int     `json:"repetitions"`
+	IntervalDays int     `json:"intervalDays"`
+	EaseFactor   float64 `json:"easeFactor"`
+	DueAt        string  `json:"dueAt"`
+}
+
+type StartSessionRequest struct {
+	Mode                    string  `json:"mode"`
+	QuestionIDs             []int64 `json:"questionIds"`
+	TotalLimitSeconds       *int    `json:"totalLimitSeconds,omitempty"`
+	PerQuestionLimitSeconds *int    `json:"perQuestionLimitSeconds,omitempty"`
+}
+
+type QuizSession struct {
+	ID                      int     `json:"id"`
+	UserID                  int     `json:"userId"`
+	Mode                    string  `json:"mode"`
+	TotalLimitSeconds       int     `json:"totalLimitSeconds"`
+	PerQuestionLimitSeconds int     `json:"perQuestionLimitSeconds"`
+	QuestionIDs             []int64 `json:"questionIds"`
+	StartedAt               string  `json:"startedAt"`
+	ExpiresAt               string  `json:"expiresAt,omitempty"`
+}
+
+type SessionSummary struct {
+	SessionID   int   `json:"sessionId"`
+	Answered    int   `json:"answered"`
+	Correct     int   `json:"correct"`
+	Incorrect   int   `json:"incorrect"`
+	TimedOut    int   `json:"timedOut"`
+	TotalTimeMs int64 `json:"totalTimeMs"`
+}
+
+type AdaptiveQuestion struct {
+	ID             int      `json:"id"`
+	Question       string   `json:"question"`
+	Type           string   `json:"type"`
+	Options        []string `json:"options,omitempty"`
+	Difficulty     string   `json:"difficulty"`
+	QuestionRating float64  `json:"questionRating"`
+	UserRating     float64  `json:"userRating"`
+}
+
+type QuestionTranslation struct {
+	QuestionID       int      `json:"questionId"`
+	Lang             string   `json:"lang"`
+	Question         string   `json:"question"`
+	CorrectAnswer    string   `json:"correct_answer"`
+	IncorrectAnswers []string `json:"incorrect_answers"`
+	CorrectAnswers   []string `json:"correct_answers,omitempty"`
+}
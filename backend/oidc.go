@@ -0,0 +1,658 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Login con un proveedor OpenID Connect: flujo authorization code con PKCE (S256).
+// El backend hace de cliente: /auth/oidc/login redirige al proveedor y
+// /auth/oidc/callback canjea el código, valida el id_token, enlaza o crea el
+// usuario y redirige al frontend con nuestro JWT en el fragmento de la URL.
+
+const (
+	oidcCookieName = "quizforge_oidc"
+	oidcFlowTTL    = 10 * time.Minute
+	// Tiempo mínimo entre descargas del JWKS al encontrar un kid desconocido
+	oidcJWKSRefresh = time.Minute
+	oidcConfigTTL   = time.Hour
+	// Sufijos que se prueban al crear el username de una cuenta nueva
+	maxUsernameSuffix = 100
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Errores del callback que se comunican al frontend por código
+var (
+	errOIDCEmailMissing      = errors.New("OIDC_EMAIL_REQUIRED")
+	errOIDCAccountConflict   = errors.New("OIDC_ACCOUNT_CONFLICT")
+	errOIDCNotProvisioned    = errors.New("OIDC_ACCOUNT_NOT_FOUND")
+	errOIDCUnknownSigningKey = errors.New("clave de firma desconocida")
+)
+
+func oidcEnabled() bool {
+	return AppConfig.OIDCIssuer != ""
+}
+
+// Metadatos del proveedor (/.well-known/openid-configuration)
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Cache de metadatos y claves públicas del proveedor
+
+type oidcProviderCache struct {
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	fetchedAt     time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+var oidcProvider = &oidcProviderCache{}
+
+func (p *oidcProviderCache) config(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.fetchedAt) < oidcConfigTTL {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	issuer := strings.TrimRight(AppConfig.OIDCIssuer, "/")
+	if err := oidcGetJSON(ctx, issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery OIDC: %w", err)
+	}
+	// El issuer anunciado debe ser exactamente el configurado (OpenID Connect Discovery §4.3)
+	if d.Issuer != AppConfig.OIDCIssuer {
+		return nil, fmt.Errorf("discovery OIDC: issuer %q no coincide con OIDC_ISSUER", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery OIDC: faltan endpoints")
+	}
+	if len(d.CodeChallengeMethods) > 0 && !containsString(d.CodeChallengeMethods, "S256") {
+		return nil, errors.New("discovery OIDC: el proveedor no admite PKCE S256")
+	}
+	p.discovery, p.fetchedAt = &d, time.Now()
+	return p.discovery, nil
+}
+
+// Clave pública por kid; si no se conoce se vuelve a descargar el JWKS
+// (el proveedor puede haber rotado sus claves)
+
+func (p *oidcProviderCache) key(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < oidcJWKSRefresh {
+		return nil, errOIDCUnknownSigningKey
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("JWKS OIDC: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	return nil, errOIDCUnknownSigningKey
+}
+
+// Sin kid solo vale si el proveedor publica una única clave
+
+func (p *oidcProviderCache) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// Clave pública en formato JWK (RFC 7517); se admiten RSA y EC P-256/P-384
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("exponente RSA inválido")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("curva %q no admitida", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("tipo de clave %q no admitido", k.Kty)
+}
+
+func oidcGetJSON(ctx context.Context, rawURL string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondió %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims del id_token que usamos
+
+type oidcIDClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp"`
+	Email             string   `json:"email"`
+	EmailVerified     oidcBool `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+// Algunos proveedores envían email_verified como cadena ("true")
+
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = oidcBool(s == "true")
+	return nil
+}
+
+// Validar firma, issuer, audiencia, caducidad y nonce del id_token
+
+func verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*oidcIDClaims, error) {
+	claims := &oidcIDClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return oidcProvider.key(ctx, d.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}),
+		jwt.WithIssuer(AppConfig.OIDCIssuer),
+		jwt.WithAudience(AppConfig.OIDCClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != AppConfig.OIDCClientID {
+		return nil, errors.New("azp no coincide con OIDC_CLIENT_ID")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token sin sub")
+	}
+	if !hmac.Equal([]byte(claims.Nonce), []byte(nonce)) {
+		return nil, errors.New("nonce no coincide")
+	}
+	return claims, nil
+}
+
+// Canjear el código de autorización (con el code_verifier de PKCE)
+
+func exchangeOIDCCode(ctx context.Context, d *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {AppConfig.OIDCRedirectURL},
+		"client_id":     {AppConfig.OIDCClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if AppConfig.OIDCClientSecret != "" {
+		// client_secret_basic (RFC 6749 §2.3.1: usuario y clave codificados como formulario)
+		req.SetBasicAuth(url.QueryEscape(AppConfig.OIDCClientID), url.QueryEscape(AppConfig.OIDCClientSecret))
+	}
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("respuesta del token endpoint: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint respondió %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("el token endpoint no devolvió id_token")
+	}
+	return body.IDToken, nil
+}
+
+// Buscar el usuario enlazado a la identidad externa; si no existe se enlaza a
+// la cuenta con el mismo email (solo si el proveedor lo verificó) o se crea una
+// nueva con OIDC_DEFAULT_ROLE
+
+func findOrProvisionOIDCUser(ctx context.Context, claims *oidcIDClaims) (User, error) {
+	var user User
+	provider := AppConfig.OIDCIssuer
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		SELECT u.id, u.email, COALESCE(u.username, ''), u.role, u.email_verified_at IS NOT NULL, u.totp_enabled_at IS NOT NULL
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2`, provider, claims.Subject).
+		Scan(&user.ID, &user.Email, &user.Username, &user.Role, &user.EmailVerified, &user.TwoFactorEnabled)
+	switch {
+	case err == nil:
+		if _, err := tx.Exec(`UPDATE user_identities SET email = $3, last_login_at = CURRENT_TIMESTAMP WHERE provider = $1 AND subject = $2`,
+			provider, claims.Subject, claims.Email); err != nil {
+			return user, err
+		}
+		return user, tx.Commit()
+	case err != sql.ErrNoRows:
+		return user, err
+	}
+
+	if claims.Email == "" {
+		return user, errOIDCEmailMissing
+	}
+	verified := bool(claims.EmailVerified)
+
+	err = tx.QueryRow(`
+		SELECT id, email, COALESCE(username, ''), role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
+		FROM users WHERE email = $1`, claims.Email).
+		Scan(&user.ID, &user.Email, &user.Username, &user.Role, &user.EmailVerified, &user.TwoFactorEnabled)
+	switch {
+	case err == nil:
+		// Sin email verificado por el proveedor, cualquiera podría apropiarse de la cuenta
+		if !verified {
+			return user, errOIDCAccountConflict
+		}
+		if _, err := tx.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1`, user.ID); err != nil {
+			return user, err
+		}
+		user.EmailVerified = true
+	case err == sql.ErrNoRows:
+		if !AppConfig.OIDCAutoProvision {
+			return user, errOIDCNotProvisioned
+		}
+		username, err := uniqueOIDCUsername(oidcUsername(claims))
+		if err != nil {
+			return user, err
+		}
+		user = User{
+			Email:         claims.Email,
+			Username:      username,
+			Role:          AppConfig.OIDCDefaultRole,
+			EmailVerified: verified,
+		}
+		// Sin contraseña local (el hash vacío nunca coincide); se puede crear con /password/forgot
+		err = tx.QueryRow(`
+			INSERT INTO users (email, username, password, role, email_verified_at)
+			VALUES ($1, $2, '', $3, CASE WHEN $4 THEN CURRENT_TIMESTAMP END)
+			RETURNING id`, user.Email, user.Username, user.Role, verified).Scan(&user.ID)
+		if err != nil {
+			return user, err
+		}
+	default:
+		return user, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`, user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return user, err
+	}
+	return user, tx.Commit()
+}
+
+// Username para una cuenta nueva: el primero de preferred_username, name o la
+// parte local del email que cumpla las reglas de validUsername tras quitar los
+// caracteres no permitidos (los espacios pasan a "_")
+
+func oidcUsername(claims *oidcIDClaims) string {
+	local, _, _ := strings.Cut(claims.Email, "@")
+	for _, name := range []string{claims.PreferredUsername, claims.Name, local} {
+		if name = sanitizeUsername(name); validUsername(name) {
+			return name
+		}
+	}
+	return "user"
+}
+
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.Join(strings.Fields(name), "_") {
+		if n == maxUsernameLength {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r) {
+			b.WriteRune(r)
+			n++
+		}
+	}
+	return b.String()
+}
+
+// Añadir un sufijo numérico (juan, juan2, juan3...) hasta dar con un username
+// libre, recortando la base para no superar maxUsernameLength
+
+func uniqueOIDCUsername(base string) (string, error) {
+	for i := 1; i <= maxUsernameSuffix; i++ {
+		name := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			runes := []rune(base)
+			name = string(runes[:min(len(runes), maxUsernameLength-len(suffix))]) + suffix
+		}
+		taken, err := identityTaken("username", name, 0)
+		if err != nil || !taken {
+			return name, err
+		}
+	}
+	return "", fmt.Errorf("no hay username libre para %q", base)
+}
+
+// Valores aleatorios de state, nonce y code_verifier (43 caracteres, RFC 7636)
+
+func oidcRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Informar al frontend si el login OIDC está disponible
+
+func GetOIDCInfo(w http.ResponseWriter, r *http.Request) {
+	info := map[string]interface{}{"enabled": oidcEnabled()}
+	if oidcEnabled() {
+		info["providerName"] = AppConfig.OIDCProviderName
+		info["loginUrl"] = "/auth/oidc/login"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+// Iniciar el login: guarda state, nonce y code_verifier en una cookie firmada
+// y redirige al proveedor
+
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, r, http.StatusNotFound, "OIDC_DISABLED")
+		return
+	}
+	d, err := oidcProvider.config(r.Context())
+	if err != nil {
+		requestLogger(r).Error("Error al consultar el proveedor OIDC", "error", err)
+		writeError(w, r, http.StatusBadGateway, "OIDC_PROVIDER_UNAVAILABLE")
+		return
+	}
+
+	var flow purposeClaims
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *v, err = oidcRandom(); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "OIDC_LOGIN_FAILED")
+			return
+		}
+	}
+	cookie, err := signPurposeToken(purposeOIDCLogin, flow, oidcFlowTTL)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "OIDC_LOGIN_FAILED")
+		return
+	}
+	setOIDCCookie(w, r, cookie, int(oidcFlowTTL.Seconds()))
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {AppConfig.OIDCClientID},
+		"redirect_uri":          {AppConfig.OIDCRedirectURL},
+		"scope":                 {strings.Join(AppConfig.OIDCScopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// Callback del proveedor: valida el flujo, emite nuestra sesión y redirige al
+// frontend (APP_BASE_URL/login/oidc#token=...) o con #error=CODIGO si falla
+
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, r, http.StatusNotFound, "OIDC_DISABLED")
+		return
+	}
+	logger := requestLogger(r)
+
+	// La cookie solo sirve para un intento
+	c, err := r.Cookie(oidcCookieName)
+	setOIDCCookie(w, r, "", -1)
+	if err != nil {
+		redirectOIDCError(w, r, "OIDC_STATE_INVALID")
+		return
+	}
+	flow, err := parsePurposeToken(purposeOIDCLogin, c.Value)
+	q := r.URL.Query()
+	if err != nil || !hmac.Equal([]byte(flow.State), []byte(q.Get("state"))) {
+		redirectOIDCError(w, r, "OIDC_STATE_INVALID")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		logger.Warn("El proveedor OIDC rechazó el login", "oidc_error", e, "description", q.Get("error_description"))
+		redirectOIDCError(w, r, "OIDC_PROVIDER_ERROR")
+		return
+	}
+	if q.Get("code") == "" {
+		redirectOIDCError(w, r, "OIDC_STATE_INVALID")
+		return
+	}
+
+	d, err := oidcProvider.config(r.Context())
+	if err != nil {
+		logger.Error("Error al consultar el proveedor OIDC", "error", err)
+		redirectOIDCError(w, r, "OIDC_PROVIDER_UNAVAILABLE")
+		return
+	}
+	rawIDToken, err := exchangeOIDCCode(r.Context(), d, q.Get("code"), flow.Verifier)
+	if err != nil {
+		logger.Error("Error al canjear el código OIDC", "error", err)
+		redirectOIDCError(w, r, "OIDC_PROVIDER_ERROR")
+		return
+	}
+	claims, err := verifyIDToken(r.Context(), d, rawIDToken, flow.Nonce)
+	if err != nil {
+		logger.Warn("id_token OIDC rechazado", "error", err)
+		redirectOIDCError(w, r, "OIDC_TOKEN_INVALID")
+		return
+	}
+
+	user, err := findOrProvisionOIDCUser(r.Context(), claims)
+	if err != nil {
+		for _, known := range []error{errOIDCEmailMissing, errOIDCAccountConflict, errOIDCNotProvisioned} {
+			if errors.Is(err, known) {
+				logger.Warn("Login OIDC rechazado", "reason", known.Error(), "subject", claims.Subject)
+				redirectOIDCError(w, r, known.Error())
+				return
+			}
+		}
+		logger.Error("Error al enlazar usuario OIDC", "error", err)
+		redirectOIDCError(w, r, "OIDC_LOGIN_FAILED")
+		return
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.UserID = user.ID
+	}
+	if !user.EmailVerified && unverifiedRestricted(restrictLogin) {
+		redirectOIDCError(w, r, "EMAIL_NOT_VERIFIED")
+		return
+	}
+	switch st, err := loadAccountState(r.Context(), user.ID); {
+	case err != nil:
+		logger.Error("Error al consultar el estado de la cuenta", "error", err)
+		redirectOIDCError(w, r, "OIDC_LOGIN_FAILED")
+		return
+	case st.Deleted:
+		redirectOIDCError(w, r, "ACCOUNT_DELETED")
+		return
+	case st.Suspension != nil:
+		redirectOIDCError(w, r, "ACCOUNT_SUSPENDED")
+		return
+	}
+
+	// El proveedor sustituye a la contraseña; si la cuenta tiene TOTP se pide igualmente
+	var result map[string]interface{}
+	if user.TwoFactorEnabled {
+		result, err = newMFAChallenge(user.ID)
+	} else {
+		result, err = newSession(r, user, false)
+	}
+	if err != nil {
+		redirectOIDCError(w, r, "OIDC_LOGIN_FAILED")
+		return
+	}
+	fragment := url.Values{}
+	for k, v := range result {
+		fragment.Set(k, fmt.Sprint(v))
+	}
+	redirectOIDCResult(w, r, fragment)
+}
+
+func setOIDCCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || AppConfig.IsProduction(),
+		// Lax para que el navegador la envíe en la redirección desde el proveedor
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// El resultado va en el fragmento para que no llegue a logs ni cabeceras Referer
+
+func redirectOIDCResult(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, strings.TrimRight(AppConfig.AppBaseURL, "/")+"/login/oidc#"+fragment.Encode(), http.StatusFound)
+}
+
+func redirectOIDCError(w http.ResponseWriter, r *http.Request, code string) {
+	redirectOIDCResult(w, r, url.Values{
+		"error":   {code},
+		"message": {errorMessage(code, errorLang(r))},
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOIDCUsername(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims oidcIDClaims
+		want   string
+	}{
+		{"preferred_username", oidcIDClaims{PreferredUsername: "juan", Name: "Juan Pérez", Email: "jp@example.com"}, "juan"},
+		{"name con espacios", oidcIDClaims{Name: "  Juan  Pérez ", Email: "jp@example.com"}, "Juan_Pérez"},
+		{"caracteres no permitidos", oidcIDClaims{PreferredUsername: "juan@corp!"}, "juancorp"},
+		{"email si el resto no vale", oidcIDClaims{PreferredUsername: "j!", Name: "@@", Email: "ana.g@example.com"}, "ana.g"},
+		{"sin candidatos válidos", oidcIDClaims{Email: "x@example.com"}, "user"},
+		{"recortado", oidcIDClaims{PreferredUsername: strings.Repeat("a", 40)}, strings.Repeat("a", maxUsernameLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := oidcUsername(&tt.claims)
+			if got != tt.want {
+				t.Errorf("oidcUsername = %q, want %q", got, tt.want)
+			}
+			if !validUsername(got) {
+				t.Errorf("oidcUsername = %q no cumple validUsername", got)
+			}
+		})
+	}
+}
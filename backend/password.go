@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignora lo que pasa de 72 bytes, así que se rechazan contraseñas más largas
+const maxPasswordBytes = 72
+
+// Clases de caracteres que puede exigir PASSWORD_REQUIRE
+var passwordClasses = map[string]struct {
+	code    string
+	matches func(r rune) bool
+}{
+	"letter": {"PASSWORD_NEEDS_LETTER", unicode.IsLetter},
+	"upper":  {"PASSWORD_NEEDS_UPPER", unicode.IsUpper},
+	"lower":  {"PASSWORD_NEEDS_LOWER", unicode.IsLower},
+	"digit":  {"PASSWORD_NEEDS_DIGIT", unicode.IsDigit},
+	"symbol": {"PASSWORD_NEEDS_SYMBOL", func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }},
+}
+
+func validPasswordClass(class string) bool {
+	_, ok := passwordClasses[class]
+	return ok
+}
+
+// Validar una contraseña contra la política configurada; devuelve el código de
+// error o "" si es válida. No puede contener el email (sin dominio) ni el username.
+
+func checkPassword(password, email, username string) string {
+	if utf8.RuneCountInString(password) < AppConfig.PasswordMinLength {
+		return "PASSWORD_TOO_SHORT"
+	}
+	if len(password) > maxPasswordBytes {
+		return "PASSWORD_TOO_LONG"
+	}
+	for _, class := range AppConfig.PasswordRequire {
+		c := passwordClasses[class]
+		if !strings.ContainsFunc(password, c.matches) {
+			return c.code
+		}
+	}
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, identity := range []string{local, strings.ToLower(username)} {
+		if len(identity) >= 3 && strings.Contains(lower, identity) {
+			return "PASSWORD_CONTAINS_IDENTITY"
+		}
+	}
+	return ""
+}
+
+// Política vigente para que el cliente pueda validar antes de enviar
+
+func GetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"minLength": AppConfig.PasswordMinLength,
+		"maxBytes":  maxPasswordBytes,
+		"require":   AppConfig.PasswordRequire,
+	})
+}
+
+// Cambiar la contraseña del usuario autenticado
+
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		writeError(w, r, http.StatusBadRequest, "PASSWORD_FIELDS_REQUIRED")
+		return
+	}
+
+	// Comparte el bloqueo de /login para que no sirva para adivinar la contraseña
+	accountKey := loginAccountKey(userID)
+	if rejectLockedLogin(w, r, accountKey) {
+		return
+	}
+
+	var email, username, hash string
+	err = DB.QueryRow(`SELECT email, COALESCE(username, ''), password FROM users WHERE id = $1`, userID).
+		Scan(&email, &username, &hash)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)); err != nil {
+		if err := recordLoginFailure(r, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeFieldError(w, r, http.StatusForbidden, "CURRENT_PASSWORD_INVALID", "currentPassword")
+		return
+	}
+	if code := checkPassword(req.NewPassword, email, username); code != "" {
+		writeFieldError(w, r, http.StatusBadRequest, code, "newPassword")
+		return
+	}
+
+	if err := setPassword(userID, req.NewPassword); err != nil {
+		requestLogger(r).Error("Error al cambiar contraseña", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_UPDATE_FAILED")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Contraseña actualizada"})
+}
+
+// Hash con el que /login compara la contraseña cuando el usuario no existe,
+// para que el tiempo de respuesta no revele qué cuentas existen. Se genera una
+// vez con el mismo coste que los hashes reales.
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     []byte
+)
+
+func dummyHash() []byte {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("quizforge-dummy-password"), AppConfig.BcryptCost)
+	})
+	return dummyPasswordHash
+}
+
+// Guardar el nuevo hash e invalidar los enlaces de restablecimiento pendientes
+
+func setPassword(userID int, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), AppConfig.BcryptCost)
+	if err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setPasswordHashTx(tx, userID, hashed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Igual que setPassword dentro de una transacción existente, con el hash ya
+// calculado (bcrypt es lento y no debe alargar la transacción)
+
+func setPasswordHashTx(tx *sql.Tx, userID int, hashed []byte) error {
+	if _, err := tx.Exec(`UPDATE users SET password = $2 WHERE id = $1`, userID, string(hashed)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL`, userID)
+	return err
+}
+
+// Solicitar un enlace de restablecimiento. Siempre responde 202 para no revelar
+// qué emails están registrados.
+
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "EMAIL_REQUIRED", "email")
+		return
+	}
+
+	accepted := func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"message": "Si el email está registrado, recibirás un enlace para restablecer la contraseña",
+		})
+	}
+
+	var userID int
+	var email string
+	err := DB.QueryRow(`SELECT id, email FROM users WHERE email = $1 AND deleted_at IS NULL`, strings.TrimSpace(req.Email)).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		accepted()
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	expiresAt := time.Now().Add(AppConfig.PasswordResetTTL)
+
+	// Solo el último enlace es válido
+	tx, err := DB.Begin()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		requestLogger(r).Error("Error al invalidar enlaces de restablecimiento", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, hashResetToken(token), expiresAt); err != nil {
+		requestLogger(r).Error("Error al guardar enlace de restablecimiento", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+
+	link := strings.TrimRight(AppConfig.AppBaseURL, "/") + "/reset-password?token=" + token
+	msg := MailMessage{
+		To:      email,
+		Subject: "Restablecer tu contraseña de QuizForge",
+		Body: fmt.Sprintf("Hola,\n\nPara elegir una nueva contraseña abre este enlace:\n\n%s\n\n"+
+			"El enlace caduca en %s y solo se puede usar una vez. Si no lo solicitaste, ignora este correo.\n",
+			link, AppConfig.PasswordResetTTL),
+	}
+	// En segundo plano: esperar al envío haría la respuesta más lenta solo
+	// cuando la cuenta existe
+	sendMailAsync(r, msg, "Error al enviar correo de restablecimiento")
+	accepted()
+}
+
+// Restablecer la contraseña con un token de un solo uso
+
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "PASSWORD_FIELDS_REQUIRED")
+		return
+	}
+
+	var resetID, userID int
+	var email, username string
+	err := DB.QueryRow(`
+		SELECT p.id, p.user_id, u.email, COALESCE(u.username, '')
+		FROM password_resets p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.token_hash = $1 AND p.used_at IS NULL AND p.expires_at > CURRENT_TIMESTAMP`,
+		hashResetToken(req.Token)).Scan(&resetID, &userID, &email, &username)
+	if err == sql.ErrNoRows {
+		writeFieldError(w, r, http.StatusBadRequest, "RESET_TOKEN_INVALID", "token")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	if code := checkPassword(req.Password, email, username); code != "" {
+		writeFieldError(w, r, http.StatusBadRequest, code, "password")
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), AppConfig.BcryptCost)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_UPDATE_FAILED")
+		return
+	}
+
+	// Marcar el token y cambiar la contraseña en la misma transacción: si dos
+	// solicitudes usan el mismo token a la vez solo una lo consigue, y si el
+	// cambio falla el token sigue sirviendo
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL`, resetID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_RESET_FAILED")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeFieldError(w, r, http.StatusBadRequest, "RESET_TOKEN_INVALID", "token")
+		return
+	}
+	if err := setPasswordHashTx(tx, userID, hashed); err != nil {
+		requestLogger(r).Error("Error al restablecer contraseña", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_UPDATE_FAILED")
+		return
+	}
+	// El enlace llegó al email, así que también queda verificado
+	if _, err := tx.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1`, userID); err != nil {
+		requestLogger(r).Error("Error al verificar email", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_UPDATE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "PASSWORD_UPDATE_FAILED")
+		return
+	}
+	// Quien demuestra acceso al email recupera también el acceso bloqueado por fallos
+	if err := rateLimitStore.Reset(loginAccountKey(userID)); err != nil {
+		requestLogger(r).Error("Error al reiniciar logins fallidos", "error", err)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Contraseña restablecida"})
+}
+
+// Token aleatorio para el enlace; en la base de datos solo se guarda su hash
+
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
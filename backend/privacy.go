@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Exportación y borrado de datos personales. /user/export entrega al titular
+// todos sus datos (JSON o ZIP con un archivo por sección). El borrado anonimiza
+// la cuenta en lugar de eliminar la fila: se quitan email, username, perfil,
+// credenciales e identidades, pero los intentos siguen asociados al ID
+// anónimo para que las estadísticas de las preguntas no cambien.
+
+// Secciones de la exportación además del perfil; single indica que como mucho
+// hay una fila y se exporta como objeto (o null)
+var exportSections = []struct {
+	name   string
+	single bool
+	query  string
+}{
+	{"identities", false, `
+		SELECT provider, subject, email, created_at AS "createdAt", last_login_at AS "lastLoginAt"
+		FROM user_identities WHERE user_id = $1 ORDER BY id`},
+	{"attempts", false, `
+		SELECT a.id, a.question_id AS "questionId", q.question, a.selected_answer AS "selectedAnswer",
+		       a.is_correct AS "isCorrect", a.answered_at AS "answeredAt", a.session_id AS "sessionId",
+		       a.time_taken_ms AS "timeTakenMs", COALESCE(a.timed_out, false) AS "timedOut"
+		FROM attempts a LEFT JOIN questions q ON q.id = a.question_id
+		WHERE a.user_id = $1 ORDER BY a.answered_at, a.id`},
+	{"summary", true, `
+		SELECT correct_count AS "correctCount", incorrect_count AS "incorrectCount", created_at AS "createdAt"
+		FROM attempt_summary WHERE user_id = $1`},
+	{"skill", true, `
+		SELECT rating, answered, updated_at AS "updatedAt" FROM user_skill WHERE user_id = $1`},
+	{"reviewCards", false, `
+		SELECT question_id AS "questionId", repetitions, interval_days AS "intervalDays", ease_factor AS "easeFactor",
+		       due_at AS "dueAt", last_reviewed_at AS "lastReviewedAt"
+		FROM review_cards WHERE user_id = $1 ORDER BY id`},
+	{"quizSessions", false, `
+		SELECT id, mode, total_limit_seconds AS "totalLimitSeconds", per_question_limit_seconds AS "perQuestionLimitSeconds",
+		       started_at AS "startedAt", finished_at AS "finishedAt"
+		FROM quiz_sessions WHERE user_id = $1 ORDER BY id`},
+}
+
+// Filas de una consulta como objetos JSON con los nombres de columna como claves
+
+func queryExportRows(ctx context.Context, query string, userID int) ([]map[string]interface{}, error) {
+	rows, err := DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []map[string]interface{}{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				row[c] = string(b)
+			} else {
+				row[c] = vals[i]
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// Reunir los datos del usuario en el orden de exportSections
+
+func collectUserData(ctx context.Context, userID int) ([]string, map[string]interface{}, error) {
+	profile, err := loadProfile(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{"profile"}
+	data := map[string]interface{}{"profile": profile}
+	for _, s := range exportSections {
+		rows, err := queryExportRows(ctx, s.query, userID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		names = append(names, s.name)
+		if !s.single {
+			data[s.name] = rows
+		} else if len(rows) > 0 {
+			data[s.name] = rows[0]
+		} else {
+			data[s.name] = nil
+		}
+	}
+	return names, data, nil
+}
+
+// Descargar los datos propios: ?format=json (por defecto) o ?format=zip
+
+func ExportMyData(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "zip":
+	default:
+		writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "format")
+		return
+	}
+
+	names, data, err := collectUserData(r.Context(), userID)
+	if err != nil {
+		requestLogger(r).Error("Error al exportar datos del usuario", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "EXPORT_FAILED")
+		return
+	}
+	exportedAt := time.Now().UTC()
+	filename := fmt.Sprintf("quizforge-export-%d-%s.%s", userID, exportedAt.Format("20060102"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	requestLogger(r).Info("Datos exportados por su titular", "user_id", userID, "format", format)
+
+	if format == "json" {
+		data["exportedAt"] = exportedAt
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(data)
+		return
+	}
+
+	// ZIP: un archivo JSON por sección; la fecha va en el comentario del archivo
+	w.Header().Set("Content-Type", "application/zip")
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name + ".json", Method: zip.Deflate, Modified: exportedAt})
+		if err != nil {
+			requestLogger(r).Error("Error al escribir la exportación", "error", err)
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data[name]); err != nil {
+			requestLogger(r).Error("Error al escribir la exportación", "error", err)
+			return
+		}
+	}
+	_ = zw.SetComment("QuizForge " + exportedAt.Format(time.RFC3339))
+	if err := zw.Close(); err != nil {
+		requestLogger(r).Error("Error al escribir la exportación", "error", err)
+	}
+}
+
+// Anonimizar una cuenta. Queda eliminada (deleted_at) y marcada con erased_at;
+// email y username pasan a "erased-<id>" para liberar los originales. Si audit
+// no es nil se llama dentro de la transacción antes de confirmarla. Devuelve
+// errLastAdmin si es el último admin activo, sql.ErrNoRows si no existe y
+// errAuditWrite si no se pudo registrar la auditoría.
+
+func eraseUser(ctx context.Context, userID int, audit func(*sql.Tx) error) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	last, err := isLastActiveAdminTx(tx, userID)
+	if err != nil {
+		return err
+	}
+	if last {
+		return errLastAdmin
+	}
+	var id int
+	err = tx.QueryRow(`
+		UPDATE users SET
+			email = 'erased-' || id || '@erased.invalid', username = 'erased-' || id, password = '',
+			email_verified_at = NULL, totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+			display_name = NULL, avatar_url = NULL, preferred_lang = NULL, preferred_categories = NULL,
+			suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL, suspended_by = NULL,
+			deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP), erased_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING id`, userID).Scan(&id)
+	if err != nil {
+		return err
+	}
+	// Los intentos, sus resúmenes y las sesiones de quiz se conservan anónimos
+	for _, q := range []string{
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+		`DELETE FROM review_cards WHERE user_id = $1`,
+		`DELETE FROM user_skill WHERE user_id = $1`,
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return err
+		}
+	}
+	if audit != nil {
+		if err := audit(tx); err != nil {
+			return fmt.Errorf("%w: %v", errAuditWrite, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Responder al error de eraseUser; devuelve true si no hubo error
+
+func writeEraseError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errLastAdmin):
+		writeError(w, r, http.StatusConflict, "LAST_ADMIN")
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+	case errors.Is(err, errAuditWrite):
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+	default:
+		requestLogger(r).Error("Error al borrar datos personales", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_ERASE_FAILED")
+	}
+	return false
+}
+
+// Borrar los datos personales propios (misma confirmación que DELETE /user/me)
+
+func EraseMyAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := confirmAccountRemoval(w, r)
+	if !ok {
+		return
+	}
+	if !writeEraseError(w, r, eraseUser(r.Context(), userID, nil)) {
+		return
+	}
+	requestLogger(r).Info("Datos personales borrados por su titular", "user_id", userID)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Datos personales borrados"})
+}
+
+// Borrar los datos personales de un usuario (admin)
+
+func EraseUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := targetUserID(w, r)
+	if !ok {
+		return
+	}
+	audit := func(tx *sql.Tx) error {
+		return recordAudit(tx, r, auditUserErase, auditTargetUser, id, nil, nil)
+	}
+	if !writeEraseError(w, r, eraseUser(r.Context(), id, audit)) {
+		return
+	}
+	requestLogger(r).Info("Datos personales borrados", "target_user_id", id)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Datos personales borrados"})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Límites de los campos del perfil
+const (
+	maxDisplayNameLength   = 80
+	maxAvatarURLLength     = 2048
+	maxPreferredCategories = 20
+	maxCategoryLength      = 100
+	minUsernameLength      = 3
+	maxUsernameLength      = 32
+)
+
+// Perfil del usuario autenticado
+
+type UserProfile struct {
+	ID                  int       `json:"id"`
+	Email               string    `json:"email"`
+	Username            string    `json:"username"`
+	Role                string    `json:"role"`
+	EmailVerified       bool      `json:"emailVerified"`
+	TwoFactorEnabled    bool      `json:"twoFactorEnabled"`
+	HasPassword         bool      `json:"hasPassword"`
+	DisplayName         string    `json:"displayName"`
+	AvatarURL           string    `json:"avatarUrl"`
+	PreferredLang       string    `json:"preferredLang"`
+	PreferredCategories []string  `json:"preferredCategories"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+func loadProfile(userID int) (UserProfile, error) {
+	var p UserProfile
+	var createdAt sql.NullTime
+	err := DB.QueryRow(`
+		SELECT id, email, COALESCE(username, ''), role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL,
+		       password <> '', COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(preferred_lang, ''),
+		       COALESCE(preferred_categories, '{}'), created_at
+		FROM users WHERE id = $1`, userID).
+		Scan(&p.ID, &p.Email, &p.Username, &p.Role, &p.EmailVerified, &p.TwoFactorEnabled,
+			&p.HasPassword, &p.DisplayName, &p.AvatarURL, &p.PreferredLang,
+			pq.Array(&p.PreferredCategories), &createdAt)
+	p.CreatedAt = createdAt.Time
+	return p, err
+}
+
+// Username: letras, números, ".", "_" y "-"
+
+func validUsername(username string) bool {
+	n := utf8.RuneCountInString(username)
+	if n < minUsernameLength || n > maxUsernameLength {
+		return false
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// Comprobar que el email o username no lo usa otra cuenta (sin distinguir mayúsculas)
+
+func identityTaken(column, value string, userID int) (bool, error) {
+	var taken bool
+	err := DB.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(%s) = LOWER($1) AND id <> $2)`, column),
+		value, userID).Scan(&taken)
+	return taken, err
+}
+
+// Validar un username y comprobar que no lo usa otra cuenta que userID (0 en
+// una cuenta nueva); responde el error y devuelve false si no se acepta
+
+func checkUsernameAvailable(w http.ResponseWriter, r *http.Request, username string, userID int) bool {
+	if !validUsername(username) {
+		writeFieldError(w, r, http.StatusBadRequest, "USERNAME_INVALID", "username")
+		return false
+	}
+	taken, err := identityTaken("username", username, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return false
+	}
+	if taken {
+		writeFieldError(w, r, http.StatusConflict, "USERNAME_TAKEN", "username")
+		return false
+	}
+	return true
+}
+
+// Consultar el perfil propio
+
+func GetMyProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	p, err := loadProfile(userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Actualizar el perfil propio. Solo se modifican los campos enviados; una
+// cadena vacía (o [] en preferredCategories) borra el valor. Cambiar el email
+// exige currentPassword y la cuenta vuelve a quedar sin verificar.
+
+func UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		DisplayName         *string   `json:"displayName"`
+		AvatarURL           *string   `json:"avatarUrl"`
+		PreferredLang       *string   `json:"preferredLang"`
+		PreferredCategories *[]string `json:"preferredCategories"`
+		Username            *string   `json:"username"`
+		Email               *string   `json:"email"`
+		CurrentPassword     string    `json:"currentPassword"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	current, err := loadProfile(userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	nullable := func(v string) interface{} {
+		if v == "" {
+			return nil
+		}
+		return v
+	}
+
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			writeFieldError(w, r, http.StatusBadRequest, "DISPLAY_NAME_INVALID", "displayName")
+			return
+		}
+		set("display_name", nullable(name))
+	}
+	if req.AvatarURL != nil {
+		avatar := strings.TrimSpace(*req.AvatarURL)
+		if avatar != "" && !validAvatarURL(avatar) {
+			writeFieldError(w, r, http.StatusBadRequest, "AVATAR_URL_INVALID", "avatarUrl")
+			return
+		}
+		set("avatar_url", nullable(avatar))
+	}
+	if req.PreferredLang != nil {
+		lang := normalizeLang(*req.PreferredLang)
+		if lang == "" && strings.TrimSpace(*req.PreferredLang) != "" {
+			writeFieldError(w, r, http.StatusBadRequest, "LANG_INVALID", "preferredLang")
+			return
+		}
+		set("preferred_lang", nullable(lang))
+	}
+	if req.PreferredCategories != nil {
+		categories := []string{}
+		for _, c := range *req.PreferredCategories {
+			c = strings.TrimSpace(c)
+			if c == "" || utf8.RuneCountInString(c) > maxCategoryLength {
+				writeFieldError(w, r, http.StatusBadRequest, "CATEGORIES_INVALID", "preferredCategories")
+				return
+			}
+			if !containsString(categories, c) {
+				categories = append(categories, c)
+			}
+		}
+		if len(categories) > maxPreferredCategories {
+			writeFieldError(w, r, http.StatusBadRequest, "CATEGORIES_INVALID", "preferredCategories")
+			return
+		}
+		set("preferred_categories", pq.Array(categories))
+	}
+
+	if req.Username != nil && *req.Username != current.Username {
+		username := strings.TrimSpace(*req.Username)
+		if !checkUsernameAvailable(w, r, username, userID) {
+			return
+		}
+		set("username", username)
+	}
+
+	emailChanged := false
+	if req.Email != nil && *req.Email != current.Email {
+		email := strings.TrimSpace(*req.Email)
+		if !validEmail(email) {
+			writeFieldError(w, r, http.StatusBadRequest, "EMAIL_INVALID", "email")
+			return
+		}
+		// Quien tenga el token no debe poder quedarse con la cuenta cambiando el email
+		if !confirmCurrentPassword(w, r, userID, req.CurrentPassword) {
+			return
+		}
+		taken, err := identityTaken("email", email, userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+			return
+		}
+		if taken {
+			writeFieldError(w, r, http.StatusConflict, "EMAIL_TAKEN", "email")
+			return
+		}
+		set("email", email)
+		sets = append(sets, "email_verified_at = NULL")
+		emailChanged = true
+	}
+
+	if len(sets) > 0 {
+		args = append(args, userID)
+		query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d`, strings.Join(sets, ", "), len(args))
+		if _, err := DB.Exec(query, args...); err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				writeError(w, r, http.StatusConflict, "USER_EXISTS")
+				return
+			}
+			requestLogger(r).Error("Error al actualizar perfil", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "PROFILE_UPDATE_FAILED")
+			return
+		}
+	}
+
+	updated, err := loadProfile(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if emailChanged {
+		if err := sendVerificationEmail(r, userID, updated.Email); err != nil {
+			requestLogger(r).Error("Error al enviar correo de verificación", "error", err)
+		}
+		// Avisar a la dirección anterior por si el cambio no lo hizo el titular
+		if err := mailer.Send(r.Context(), MailMessage{
+			To:      current.Email,
+			Subject: "Tu email de QuizForge ha cambiado",
+			Body: fmt.Sprintf("Hola,\n\nEl email de tu cuenta de QuizForge se ha cambiado a %s.\n\n"+
+				"Si no fuiste tú, restablece tu contraseña y contacta con un administrador.\n", updated.Email),
+		}); err != nil {
+			requestLogger(r).Error("Error al avisar del cambio de email", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(updated)
+}
+
+// Comprobar la contraseña actual para operaciones sensibles; comparte el
+// bloqueo de /login. Escribe la respuesta de error y devuelve false si no vale.
+
+func confirmCurrentPassword(w http.ResponseWriter, r *http.Request, userID int, password string) bool {
+	if password == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "CURRENT_PASSWORD_REQUIRED", "currentPassword")
+		return false
+	}
+	accountKey := loginAccountKey(userID)
+	if rejectLockedLogin(w, r, accountKey) {
+		return false
+	}
+	var hash string
+	if err := DB.QueryRow(`SELECT password FROM users WHERE id = $1`, userID).Scan(&hash); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if err := recordLoginFailure(r, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeFieldError(w, r, http.StatusForbidden, "CURRENT_PASSWORD_INVALID", "currentPassword")
+		return false
+	}
+	return true
+}
+
+// Eliminar la cuenta propia (borrado lógico)
+
+func DeleteMyAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := confirmAccountRemoval(w, r)
+	if !ok {
+		return
+	}
+	// La comprobación de que queda otro admin y el borrado van en la misma
+	// transacción (ver isLastActiveAdminTx)
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	last, err := isLastActiveAdminTx(tx, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if last {
+		writeError(w, r, http.StatusConflict, "LAST_ADMIN")
+		return
+	}
+	if _, err := tx.Exec(`UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, userID); err != nil {
+		requestLogger(r).Error("Error al eliminar cuenta", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_DELETE_FAILED")
+		return
+	}
+	requestLogger(r).Info("Cuenta eliminada por su titular", "user_id", userID)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Cuenta eliminada"})
+}
+
+// Confirmar que el titular quiere eliminar (o borrar) su cuenta: con
+// currentPassword o, en cuentas sin contraseña local (creadas por OIDC),
+// escribiendo el email en confirmEmail. No se permite dejar el sistema sin admins.
+
+func confirmAccountRemoval(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return 0, false
+	}
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		ConfirmEmail    string `json:"confirmEmail"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return 0, false
+	}
+
+	p, err := loadProfile(userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "USER_NOT_FOUND")
+		return 0, false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return 0, false
+	}
+	if p.HasPassword {
+		if !confirmCurrentPassword(w, r, userID, req.CurrentPassword) {
+			return 0, false
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(req.ConfirmEmail), p.Email) {
+		writeFieldError(w, r, http.StatusBadRequest, "DELETE_CONFIRMATION_INVALID", "confirmEmail")
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Almacén del limitador. La implementación en memoria solo sirve para una
+// instancia; con varias réplicas se puede implementar sobre Redis u otro backend.
+
+type RateLimitStore interface {
+	// Incrementa el contador de key en una ventana fija y devuelve el valor
+	// actual y el momento en que la ventana se reinicia
+	Incr(key string, window time.Duration) (count int, resetAt time.Time, err error)
+	// Borra el contador de key
+	Reset(key string) error
+	// Bloquea key hasta until
+	Lock(key string, until time.Time) error
+	// Momento hasta el que key está bloqueada (cero si no lo está)
+	LockedUntil(key string) (time.Time, error)
+}
+
+// Almacén compartido por todos los limitadores
+var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+// Límite de N solicitudes por ventana; Limit 0 lo desactiva
+
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Limit > 0 && l.Window > 0
+}
+
+func (l RateLimit) String() string {
+	if !l.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", l.Limit, l.Window)
+}
+
+// Formato "N/duración", p. ej. "10/1m"; "0" desactiva el límite
+
+func parseRateLimit(v string) (RateLimit, error) {
+	if strings.TrimSpace(v) == "0" {
+		return RateLimit{}, nil
+	}
+	n, d, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("formato esperado N/duración, recibido %q", v)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || limit < 0 {
+		return RateLimit{}, fmt.Errorf("límite inválido %q", n)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(d))
+	if err != nil || window <= 0 {
+		return RateLimit{}, fmt.Errorf("ventana inválida %q", d)
+	}
+	return RateLimit{Limit: limit, Window: window}, nil
+}
+
+// Middleware que limita por IP las solicitudes a un handler. name separa los
+// contadores de cada ruta (login, register, import...).
+
+func RateLimitMiddleware(next http.HandlerFunc, name string, limit RateLimit) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limit.Enabled() {
+			next(w, r)
+			return
+		}
+		count, resetAt, err := rateLimitStore.Incr("rl:"+name+":"+clientIP(r), limit.Window)
+		if err != nil {
+			// Si el almacén falla se deja pasar la solicitud en lugar de bloquear el servicio
+			requestLogger(r).Error("Error en el limitador de solicitudes", "limiter", name, "error", err)
+			next(w, r)
+			return
+		}
+
+		remaining := limit.Limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+		if count > limit.Limit {
+			rateLimitedTotal.Inc(name)
+			writeTooManyRequests(w, r, "RATE_LIMITED", resetAt)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, code string, until time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(until)))
+	writeError(w, r, http.StatusTooManyRequests, code)
+}
+
+func retryAfterSeconds(until time.Time) int {
+	secs := int(math.Ceil(time.Until(until).Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// IP del cliente; con TRUST_PROXY_HEADERS se usa la última dirección de
+// X-Forwarded-For (la añadida por el proxy de confianza)
+
+func clientIP(r *http.Request) string {
+	if AppConfig.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Protección contra fuerza bruta en /login: los fallos se cuentan por IP y
+// por cuenta; al superar el umbral la clave se bloquea durante LOGIN_LOCKOUT_BASE,
+// duplicando el bloqueo con cada fallo adicional hasta LOGIN_LOCKOUT_MAX
+
+func loginIPKey(r *http.Request) string {
+	return "login:ip:" + clientIP(r)
+}
+
+// La cuenta se identifica por su ID para que alternar email y username no reparta los fallos
+
+func loginAccountKey(userID int) string {
+	return "login:account:" + strconv.Itoa(userID)
+}
+
+func recordLoginFailure(r *http.Request, keys ...string) error {
+	for _, key := range keys {
+		threshold := AppConfig.LoginMaxFailures
+		if strings.HasPrefix(key, "login:ip:") {
+			threshold = AppConfig.LoginMaxFailuresPerIP
+		}
+		if threshold <= 0 {
+			continue
+		}
+		// Los fallos se cuentan en ventanas de LOGIN_LOCKOUT_MAX
+		failures, _, err := rateLimitStore.Incr(key, AppConfig.LoginLockoutMax)
+		if err != nil {
+			return err
+		}
+		if failures < threshold {
+			continue
+		}
+		lockout := lockoutDuration(failures-threshold, AppConfig.LoginLockoutBase, AppConfig.LoginLockoutMax)
+		if err := rateLimitStore.Lock(key, time.Now().Add(lockout)); err != nil {
+			return err
+		}
+		requestLogger(r).Warn("Login bloqueado por intentos fallidos", "key", key, "failures", failures, "lockout", lockout.String())
+	}
+	return nil
+}
+
+// Responder 429 si la clave está bloqueada; si el almacén falla se permite el intento
+
+func rejectLockedLogin(w http.ResponseWriter, r *http.Request, key string) bool {
+	until, err := rateLimitStore.LockedUntil(key)
+	if err != nil {
+		requestLogger(r).Error("Error al consultar bloqueo de login", "error", err)
+		return false
+	}
+	if until.IsZero() {
+		return false
+	}
+	rateLimitedTotal.Inc("login_lockout")
+	writeTooManyRequests(w, r, "LOGIN_LOCKED", until)
+	return true
+}
+
+func lockoutDuration(extraFailures int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < extraFailures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Almacén en memoria con ventanas fijas; las entradas vencidas se limpian
+// periódicamente al escribir
+
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]memoryCounter
+	locks     map[string]time.Time
+	lastSweep time.Time
+}
+
+type memoryCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+const memoryStoreSweepInterval = time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		counters:  map[string]memoryCounter{},
+		locks:     map[string]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Incr(key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = memoryCounter{resetAt: now.Add(window)}
+	}
+	c.count++
+	s.counters[key] = c
+	return c.count, c.resetAt, nil
+}
+
+func (s *MemoryRateLimitStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	delete(s.locks, key)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = until
+	return nil
+}
+
+func (s *MemoryRateLimitStore) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.locks[key]
+	if !ok || !time.Now().Before(until) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryStoreSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !now.Before(until) {
+			delete(s.locks, key)
+		}
+	}
+}
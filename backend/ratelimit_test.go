@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		name          string
+		extraFailures int
+		base, max     time.Duration
+		want          time.Duration
+	}{
+		{"primer bloqueo", 0, time.Minute, 15 * time.Minute, time.Minute},
+		{"un fallo más duplica", 1, time.Minute, 15 * time.Minute, 2 * time.Minute},
+		{"tres fallos más", 3, time.Minute, 15 * time.Minute, 8 * time.Minute},
+		{"tope", 4, time.Minute, 15 * time.Minute, 15 * time.Minute},
+		{"muchos fallos no desbordan", 1000, time.Minute, 15 * time.Minute, 15 * time.Minute},
+		{"base mayor que el tope", 0, time.Hour, 15 * time.Minute, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockoutDuration(tt.extraFailures, tt.base, tt.max); got != tt.want {
+				t.Errorf("lockoutDuration(%d, %v, %v) = %v, want %v", tt.extraFailures, tt.base, tt.max, got, tt.want)
+			}
+		})
+	}
+}
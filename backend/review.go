@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Valores iniciales del algoritmo SM-2
+const (
+	defaultEaseFactor = 2.5
+	minEaseFactor     = 1.3
+)
+
+// Tarjeta de repaso de un usuario para una pregunta
+
+type ReviewCard struct {
+	Repetitions  int
+	IntervalDays int
+	EaseFactor   float64
+}
+
+// Aplicar SM-2: quality va de 0 (olvido total) a 5 (respuesta perfecta)
+
+func (c ReviewCard) Next(quality int) ReviewCard {
+	if quality < 0 {
+		quality = 0
+	}
+	if quality > 5 {
+		quality = 5
+	}
+
+	next := c
+	if quality < 3 {
+		next.Repetitions = 0
+		next.IntervalDays = 1
+	} else {
+		switch c.Repetitions {
+		case 0:
+			next.IntervalDays = 1
+		case 1:
+			next.IntervalDays = 6
+		default:
+			next.IntervalDays = int(math.Round(float64(c.IntervalDays) * c.EaseFactor))
+		}
+		next.Repetitions = c.Repetitions + 1
+	}
+
+	q := float64(5 - quality)
+	next.EaseFactor = c.EaseFactor + (0.1 - q*(0.08+q*0.02))
+	if next.EaseFactor < minEaseFactor {
+		next.EaseFactor = minEaseFactor
+	}
+	return next
+}
+
+// Programar una pregunta fallada para repaso (se llama al guardar un intento incorrecto)
+
+func scheduleMissedQuestion(userID, questionID int) error {
+	_, err := DB.Exec(`
+		INSERT INTO review_cards (user_id, question_id, repetitions, interval_days, ease_factor, due_at)
+		VALUES ($1, $2, 0, 1, $3, CURRENT_TIMESTAMP + INTERVAL '1 day')
+		ON CONFLICT (user_id, question_id)
+		DO UPDATE SET repetitions = 0, interval_days = 1, due_at = CURRENT_TIMESTAMP + INTERVAL '1 day'`,
+		userID, questionID, defaultEaseFactor)
+	return err
+}
+
+// Preguntas pendientes de repaso del usuario
+
+func GetReviewQuestions(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	rows, err := DB.Query(`
+		SELECT q.id, q.question, q.correct_answer, q.incorrect_answers,
+		       COALESCE(q.type, 'multiple'), COALESCE(q.correct_answers, '{}'),
+		       c.repetitions, c.interval_days, c.ease_factor, c.due_at
+		FROM review_cards c
+		JOIN questions q ON c.question_id = q.id
+		WHERE c.user_id = $1 AND c.due_at <= CURRENT_TIMESTAMP
+		ORDER BY c.due_at
+		LIMIT $2`, userID, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "REVIEW_FETCH_FAILED")
+		return
+	}
+	defer rows.Close()
+
+	items := []ReviewItem{}
+	questions := []Question{}
+	for rows.Next() {
+		var it ReviewItem
+		var q Question
+		var dueAt time.Time
+		if err := rows.Scan(&q.ID, &q.Question, &q.CorrectAnswer, pq.Array(&q.IncorrectAnswers),
+			&q.Type, pq.Array(&q.CorrectAnswers), &it.Repetitions, &it.IntervalDays, &it.EaseFactor, &dueAt); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "REVIEW_FETCH_FAILED")
+			return
+		}
+		it.DueAt = dueAt.Format(time.RFC3339)
+		items = append(items, it)
+		questions = append(questions, q)
+	}
+
+	if err := applyTranslations(questions, requestLang(r)); err != nil {
+		requestLogger(r).Warn("No se pudieron aplicar traducciones", "error", err)
+	}
+	// La clave de respuestas no sale del servidor: las opciones van mezcladas
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i, q := range questions {
+		items[i].ID, items[i].Question, items[i].Type, items[i].Lang = q.ID, q.Question, q.Type, q.Lang
+		items[i].Options = shuffledOptions(q, rng)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// Calificar respuestas de repaso y reprogramar cada tarjeta. Solo se califican
+// tarjetas vencidas y todo el lote se guarda en una transacción.
+
+func GradeReviewAnswers(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	var grades []ReviewGrade
+	if err := json.NewDecoder(r.Body).Decode(&grades); err != nil || len(grades) == 0 {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "REVIEW_SAVE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+
+	results := make([]ReviewResult, 0, len(grades))
+	for _, g := range grades {
+		var card ReviewCard
+		var due bool
+		err := tx.QueryRow(`
+			SELECT repetitions, interval_days, ease_factor, due_at <= CURRENT_TIMESTAMP
+			FROM review_cards
+			WHERE user_id = $1 AND question_id = $2
+			FOR UPDATE`, userID, g.QuestionID).
+			Scan(&card.Repetitions, &card.IntervalDays, &card.EaseFactor, &due)
+		if err == sql.ErrNoRows {
+			writeError(w, r, http.StatusNotFound, "REVIEW_CARD_NOT_FOUND")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "REVIEW_FETCH_FAILED")
+			return
+		}
+		// Recalificar una tarjeta no vencida inflaría sus intervalos
+		if !due {
+			writeError(w, r, http.StatusConflict, "REVIEW_CARD_NOT_DUE")
+			return
+		}
+
+		key, err := loadQuestionKey(g.QuestionID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+			return
+		}
+		isCorrect := gradeAnswer(key, g.SelectedAnswer, g.SelectedAnswers)
+		selected := g.SelectedAnswer
+		if key.Type == QuestionMultiSelect && len(g.SelectedAnswers) > 0 {
+			selected = joinMultiAnswer(g.SelectedAnswers)
+		}
+
+		next := card.Next(reviewQuality(isCorrect, g.Quality))
+
+		var dueAt time.Time
+		if err := tx.QueryRow(`
+			UPDATE review_cards
+			SET repetitions = $3, interval_days = $4, ease_factor = $5,
+			    due_at = CURRENT_TIMESTAMP + make_interval(days => $4), last_reviewed_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND question_id = $2
+			RETURNING due_at`,
+			userID, g.QuestionID, next.Repetitions, next.IntervalDays, next.EaseFactor).Scan(&dueAt); err != nil {
+			requestLogger(r).Error("Error al actualizar tarjeta de repaso", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "REVIEW_SAVE_FAILED")
+			return
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO attempts (user_id, question_id, selected_answer, is_correct)
+			VALUES ($1, $2, $3, $4)`,
+			userID, g.QuestionID, selected, isCorrect); err != nil {
+			requestLogger(r).Error("Error al guardar intento de repaso", "error", err)
+			writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
+			return
+		}
+
+		results = append(results, ReviewResult{
+			QuestionID:   g.QuestionID,
+			IsCorrect:    isCorrect,
+			Repetitions:  next.Repetitions,
+			IntervalDays: next.IntervalDays,
+			EaseFactor:   next.EaseFactor,
+			DueAt:        dueAt.Format(time.RFC3339),
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "REVIEW_SAVE_FAILED")
+		return
+	}
+	for _, res := range results {
+		recordAnswer("review", res.IsCorrect)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// Calidad SM-2 de una respuesta: si el cliente no la envía se deriva del
+// acierto, y una respuesta incorrecta nunca cuenta como recordada (>= 3)
+
+func reviewQuality(isCorrect bool, requested *int) int {
+	if requested == nil {
+		if isCorrect {
+			return 4
+		}
+		return 1
+	}
+	if !isCorrect && *requested >= 3 {
+		return 2
+	}
+	return *requested
+}
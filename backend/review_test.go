@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReviewCardNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		card    ReviewCard
+		quality int
+		want    ReviewCard
+	}{
+		{"primer acierto", ReviewCard{0, 0, 2.5}, 5, ReviewCard{1, 1, 2.6}},
+		{"segundo acierto", ReviewCard{1, 1, 2.6}, 4, ReviewCard{2, 6, 2.6}},
+		{"intervalo por factor", ReviewCard{2, 6, 2.5}, 3, ReviewCard{3, 15, 2.36}},
+		{"fallo reinicia", ReviewCard{3, 15, 2.5}, 1, ReviewCard{0, 1, 1.96}},
+		{"factor mínimo", ReviewCard{0, 1, minEaseFactor}, 0, ReviewCard{0, 1, minEaseFactor}},
+		{"calidad mayor que 5", ReviewCard{0, 0, 2.5}, 9, ReviewCard{1, 1, 2.6}},
+		{"calidad negativa", ReviewCard{2, 6, 2.5}, -3, ReviewCard{0, 1, 1.7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.card.Next(tt.quality)
+			if got.Repetitions != tt.want.Repetitions || got.IntervalDays != tt.want.IntervalDays ||
+				math.Abs(got.EaseFactor-tt.want.EaseFactor) > 1e-9 {
+				t.Errorf("Next(%d) = %+v, want %+v", tt.quality, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReviewQuality(t *testing.T) {
+	q := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		isCorrect bool
+		requested *int
+		want      int
+	}{
+		{"acierto sin calidad", true, nil, 4},
+		{"fallo sin calidad", false, nil, 1},
+		{"calidad del cliente", true, q(5), 5},
+		{"fallo no cuenta como recordado", false, q(5), 2},
+		{"fallo con calidad baja", false, q(0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reviewQuality(tt.isCorrect, tt.requested); got != tt.want {
+				t.Errorf("reviewQuality = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+)
+
+// Servidor HTTP con timeouts configurables y apagado ordenado: al recibir
+// SIGINT/SIGTERM deja de aceptar conexiones, espera a que terminen las
+// solicitudes en curso (hasta SHUTDOWN_TIMEOUT) y cierra la base de datos
+
+func runServer(handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              AppConfig.Addr,
+		Handler:           handler,
+		ReadTimeout:       AppConfig.ReadTimeout,
+		ReadHeaderTimeout: AppConfig.ReadHeaderTimeout,
+		WriteTimeout:      AppConfig.WriteTimeout,
+		IdleTimeout:       AppConfig.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if AppConfig.TLSEnabled() {
+			slog.Info("Servidor HTTPS corriendo", "addr", AppConfig.Addr, "env", AppConfig.Env)
+			serveErr <- srv.ListenAndServeTLS(AppConfig.TLSCertFile, AppConfig.TLSKeyFile)
+			return
+		}
+		slog.Info("Servidor corriendo", "addr", AppConfig.Addr, "env", AppConfig.Env)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Info("Señal de apagado recibida, terminando solicitudes en curso")
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), AppConfig.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("Apagado forzado", "error", err)
+	}
+
+	if DB != nil {
+		if cerr := DB.Close(); cerr != nil {
+			slog.Warn("Error al cerrar la base de datos", "error", cerr)
+		}
+	}
+	slog.Info("Servidor detenido")
+	return err
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Modos de quiz con sus límites de tiempo por defecto (en segundos, 0 = sin límite)
+
+var quizModes = map[string]struct {
+	TotalLimit       int
+	PerQuestionLimit int
+}{
+	"libre":        {0, 0},
+	"contrarreloj": {300, 0},
+	"rapido":       {0, 20},
+	"examen":       {600, 60},
+}
+
+// Margen para compensar la latencia de red al validar los límites
+const timeLimitGrace = 2 * time.Second
+
+// Máximo de preguntas por sesión
+const maxSessionQuestions = 100
+
+// Iniciar una sesión de quiz (el servidor registra la hora de inicio)
+
+func StartQuizSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	var req StartSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = "libre"
+	}
+	mode, ok := quizModes[req.Mode]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "QUIZ_MODE_INVALID")
+		return
+	}
+
+	// Los límites explícitos reemplazan a los del modo
+	if req.TotalLimitSeconds != nil {
+		mode.TotalLimit = *req.TotalLimitSeconds
+	}
+	if req.PerQuestionLimitSeconds != nil {
+		mode.PerQuestionLimit = *req.PerQuestionLimitSeconds
+	}
+	if mode.TotalLimit < 0 || mode.PerQuestionLimit < 0 {
+		writeError(w, r, http.StatusBadRequest, "TIME_LIMITS_INVALID")
+		return
+	}
+
+	ids, ok := sessionQuestionIDs(req.QuestionIDs)
+	if !ok {
+		writeFieldError(w, r, http.StatusBadRequest, "SESSION_QUESTIONS_INVALID", "questionIds")
+		return
+	}
+	var found int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM questions WHERE id = ANY($1)`, pq.Array(ids)).Scan(&found); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "SESSION_START_FAILED")
+		return
+	}
+	if found != len(ids) {
+		writeFieldError(w, r, http.StatusBadRequest, "SESSION_QUESTIONS_INVALID", "questionIds")
+		return
+	}
+
+	s := QuizSession{
+		UserID:                  userID,
+		Mode:                    req.Mode,
+		TotalLimitSeconds:       mode.TotalLimit,
+		PerQuestionLimitSeconds: mode.PerQuestionLimit,
+		QuestionIDs:             ids,
+	}
+	// El fin se calcula en la base de datos con el mismo reloj que started_at
+	var startedAt, expiresAt time.Time
+	err = DB.QueryRow(`
+		INSERT INTO quiz_sessions (user_id, mode, total_limit_seconds, per_question_limit_seconds, question_ids)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, started_at, started_at + make_interval(secs => total_limit_seconds)`,
+		userID, s.Mode, s.TotalLimitSeconds, s.PerQuestionLimitSeconds, pq.Array(ids)).Scan(&s.ID, &startedAt, &expiresAt)
+	if err != nil {
+		requestLogger(r).Error("Error al crear sesión de quiz", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "SESSION_START_FAILED")
+		return
+	}
+	s.StartedAt = startedAt.Format(time.RFC3339)
+	if s.TotalLimitSeconds > 0 {
+		s.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+// Validar las preguntas de una sesión: al menos una, sin repetidas y como
+// mucho maxSessionQuestions
+
+func sessionQuestionIDs(ids []int64) ([]int64, bool) {
+	if len(ids) == 0 || len(ids) > maxSessionQuestions {
+		return nil, false
+	}
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			return nil, false
+		}
+		seen[id] = true
+	}
+	return ids, true
+}
+
+// Bloquear una sesión del usuario para registrar una respuesta. Los tiempos se
+// miden en la base de datos (CURRENT_TIMESTAMP frente a started_at y a la
+// última respuesta) para no mezclar el reloj del servidor con columnas sin
+// zona horaria. El bloqueo serializa las respuestas de la sesión.
+
+func lockQuizSession(tx *sql.Tx, id, userID int) (*quizSessionRow, error) {
+	var s quizSessionRow
+	var elapsedMs, sinceLastMs int64
+	err := tx.QueryRow(`
+		SELECT id, mode, total_limit_seconds, per_question_limit_seconds, finished_at IS NOT NULL, question_ids,
+		       (EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - started_at) * 1000)::BIGINT,
+		       (EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - COALESCE(
+		           (SELECT MAX(answered_at) FROM attempts WHERE session_id = quiz_sessions.id), started_at)) * 1000)::BIGINT
+		FROM quiz_sessions
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE`, id, userID).
+		Scan(&s.ID, &s.Mode, &s.TotalLimit, &s.PerQuestionLimit, &s.Finished, pq.Array(&s.QuestionIDs), &elapsedMs, &sinceLastMs)
+	if err != nil {
+		return nil, err
+	}
+	s.Elapsed = time.Duration(elapsedMs) * time.Millisecond
+	s.SinceLast = time.Duration(sinceLastMs) * time.Millisecond
+	return &s, nil
+}
+
+type quizSessionRow struct {
+	ID               int
+	Mode             string
+	TotalLimit       int
+	PerQuestionLimit int
+	Finished         bool
+	QuestionIDs      []int64
+	// Tiempo desde el inicio y desde la respuesta anterior (o el inicio si es la primera)
+	Elapsed   time.Duration
+	SinceLast time.Duration
+}
+
+// Indica si la respuesta llega fuera del límite total o del límite por pregunta
+
+func (s *quizSessionRow) timedOut() bool {
+	if s.TotalLimit > 0 && s.Elapsed > time.Duration(s.TotalLimit)*time.Second+timeLimitGrace {
+		return true
+	}
+	return s.PerQuestionLimit > 0 && s.SinceLast > time.Duration(s.PerQuestionLimit)*time.Second+timeLimitGrace
+}
+
+// Registrar una respuesta dentro de una sesión. Solo se aceptan preguntas de la
+// sesión y una respuesta por pregunta; las respuestas fuera de tiempo se
+// guardan como incorrectas y marcadas con timed_out
+
+func AnswerQuizSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "SESSION_ID_INVALID")
+		return
+	}
+
+	var a AttemptAnswer
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+
+	s, err := lockQuizSession(tx, sessionID, userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "SESSION_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "SESSION_FETCH_FAILED")
+		return
+	}
+	if s.Finished {
+		writeError(w, r, http.StatusConflict, "SESSION_FINISHED")
+		return
+	}
+	if !slices.Contains(s.QuestionIDs, int64(a.QuestionID)) {
+		writeFieldError(w, r, http.StatusBadRequest, "QUESTION_NOT_IN_SESSION", "questionId")
+		return
+	}
+	var answered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM attempts WHERE session_id = $1 AND question_id = $2)`,
+		sessionID, a.QuestionID).Scan(&answered); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "SESSION_FETCH_FAILED")
+		return
+	}
+	if answered {
+		writeError(w, r, http.StatusConflict, "QUESTION_ALREADY_ANSWERED")
+		return
+	}
+
+	key, err := loadQuestionKey(a.QuestionID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "QUESTION_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+	if key.Type == QuestionMultiSelect && len(a.SelectedAnswers) > 0 {
+		a.SelectedAnswer = joinMultiAnswer(a.SelectedAnswers)
+	}
+
+	timedOut := s.timedOut()
+	isCorrect := !timedOut && gradeAnswer(key, a.SelectedAnswer, a.SelectedAnswers)
+	taken := s.SinceLast
+
+	if _, err := tx.Exec(`
+		INSERT INTO attempts (user_id, question_id, selected_answer, is_correct, answered_at, session_id, time_taken_ms, timed_out)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, $5, $6, $7)`,
+		userID, a.QuestionID, a.SelectedAnswer, isCorrect, sessionID, taken.Milliseconds(), timedOut); err != nil {
+		requestLogger(r).Error("Error al guardar intento de sesión", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "ATTEMPT_SAVE_FAILED")
+		return
+	}
+	recordAnswer("session", isCorrect)
+	if !isCorrect {
+		if err := scheduleMissedQuestion(userID, a.QuestionID); err != nil {
+			requestLogger(r).Warn("No se pudo programar repaso", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"questionId":  a.QuestionID,
+		"isCorrect":   isCorrect,
+		"timedOut":    timedOut,
+		"timeTakenMs": taken.Milliseconds(),
+	})
+}
+
+// Finalizar la sesión y devolver su resumen
+
+func FinishQuizSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "SESSION_ID_INVALID")
+		return
+	}
+
+	res, err := DB.Exec(`
+		UPDATE quiz_sessions SET finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND finished_at IS NULL`, sessionID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "SESSION_FINISH_FAILED")
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		quizzesCompletedTotal.Inc("session")
+	} else {
+		var exists bool
+		err := DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM quiz_sessions WHERE id = $1 AND user_id = $2)`,
+			sessionID, userID).Scan(&exists)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "SESSION_FETCH_FAILED")
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, "SESSION_NOT_FOUND")
+			return
+		}
+	}
+
+	var summary SessionSummary
+	err = DB.QueryRow(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE is_correct), COUNT(*) FILTER (WHERE timed_out),
+		       COALESCE(SUM(time_taken_ms), 0)
+		FROM attempts
+		WHERE session_id = $1`, sessionID).
+		Scan(&summary.Answered, &summary.Correct, &summary.TimedOut, &summary.TotalTimeMs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "SESSION_FETCH_FAILED")
+		return
+	}
+	summary.SessionID = sessionID
+	summary.Incorrect = summary.Answered - summary.Correct
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(summary)
+}
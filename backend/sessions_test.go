@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuizSessionTimedOut(t *testing.T) {
+	tests := []struct {
+		name               string
+		total, perQuestion int
+		elapsed, sinceLast time.Duration
+		want               bool
+	}{
+		{"sin límites", 0, 0, time.Hour, time.Hour, false},
+		{"dentro del límite total", 300, 0, 300 * time.Second, 10 * time.Second, false},
+		{"margen de latencia", 300, 0, 300*time.Second + timeLimitGrace, time.Second, false},
+		{"fuera del límite total", 300, 0, 303 * time.Second, time.Second, true},
+		{"fuera del límite por pregunta", 0, 20, time.Minute, 23 * time.Second, true},
+		{"dentro del límite por pregunta", 600, 60, 5 * time.Minute, 61 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := quizSessionRow{TotalLimit: tt.total, PerQuestionLimit: tt.perQuestion, Elapsed: tt.elapsed, SinceLast: tt.sinceLast}
+			if got := s.timedOut(); got != tt.want {
+				t.Errorf("timedOut() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionQuestionIDs(t *testing.T) {
+	tooMany := make([]int64, maxSessionQuestions+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+	tests := []struct {
+		name string
+		ids  []int64
+		want bool
+	}{
+		{"válidas", []int64{3, 1, 2}, true},
+		{"vacía", nil, false},
+		{"repetidas", []int64{1, 2, 1}, false},
+		{"id no positivo", []int64{1, 0}, false},
+		{"demasiadas", tooMany, false},
+		{"justo el máximo", tooMany[:maxSessionQuestions], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := sessionQuestionIDs(tt.ids); ok != tt.want {
+				t.Errorf("sessionQuestionIDs(%v) ok = %v, want %v", tt.ids, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Intentos mínimos en una categoría para considerarla entre las más débiles
+const minAttemptsWeakCategory = 5
+
+// Intentos que cuentan en las estadísticas: todas las cifras (totales, rachas y
+// tendencia) usan el mismo JOIN para que cuadren entre sí
+const statsAttemptsFrom = `
+		FROM attempts a
+		JOIN questions q ON a.question_id = q.id
+		WHERE a.user_id = $1`
+
+// Estadísticas del usuario: precisión por categoría/dificultad, rachas y tendencia
+
+func GetUserStats(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+
+	// Agrupación de la tendencia: day, week o month
+	bucket := r.URL.Query().Get("bucket")
+	switch bucket {
+	case "":
+		bucket = "week"
+	case "day", "week", "month":
+	default:
+		writeFieldError(w, r, http.StatusBadRequest, "INVALID_PARAMETER", "bucket")
+		return
+	}
+
+	stats := UserStats{UserID: userID}
+
+	stats.ByCategory, err = accuracyBy(userID, "q.categoria")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "STATS_FETCH_FAILED")
+		return
+	}
+	stats.ByDifficulty, err = accuracyBy(userID, "q.dificultad")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "STATS_FETCH_FAILED")
+		return
+	}
+
+	for _, c := range stats.ByCategory {
+		stats.TotalAnswered += c.Total
+		stats.TotalCorrect += c.Correct
+	}
+	if stats.TotalAnswered > 0 {
+		stats.Accuracy = percentage(stats.TotalCorrect, stats.TotalAnswered)
+	}
+
+	stats.CurrentStreak, stats.BestStreak, err = answerStreaks(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "STATS_FETCH_FAILED")
+		return
+	}
+
+	stats.Trend, err = accuracyTrend(userID, bucket)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "STATS_FETCH_FAILED")
+		return
+	}
+
+	limit := 3
+	if v := r.URL.Query().Get("weakest"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	stats.WeakestCategories = weakestCategories(stats.ByCategory, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// Precisión agrupada por una columna de questions (categoria o dificultad)
+
+func accuracyBy(userID int, column string) ([]AccuracyStat, error) {
+	rows, err := DB.Query(`
+		SELECT COALESCE(`+column+`, ''), COUNT(*), COUNT(*) FILTER (WHERE a.is_correct)`+statsAttemptsFrom+`
+		GROUP BY 1
+		ORDER BY 1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []AccuracyStat{}
+	for rows.Next() {
+		var s AccuracyStat
+		if err := rows.Scan(&s.Name, &s.Total, &s.Correct); err != nil {
+			return nil, err
+		}
+		s.Accuracy = percentage(s.Correct, s.Total)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// Racha actual y mejor racha de respuestas correctas consecutivas
+
+func answerStreaks(userID int) (current, best int, err error) {
+	rows, err := DB.Query(`
+		SELECT COALESCE(a.is_correct, false)`+statsAttemptsFrom+`
+		ORDER BY a.answered_at, a.id`, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var correct bool
+		if err := rows.Scan(&correct); err != nil {
+			return 0, 0, err
+		}
+		if correct {
+			current++
+			if current > best {
+				best = current
+			}
+		} else {
+			current = 0
+		}
+	}
+	return current, best, rows.Err()
+}
+
+// Precisión por periodo de tiempo (date_trunc sobre answered_at)
+
+func accuracyTrend(userID int, bucket string) ([]TrendPoint, error) {
+	rows, err := DB.Query(`
+		SELECT date_trunc($2, a.answered_at) AS periodo, COUNT(*), COUNT(*) FILTER (WHERE a.is_correct)`+statsAttemptsFrom+`
+		GROUP BY periodo
+		ORDER BY periodo`, userID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trend := []TrendPoint{}
+	for rows.Next() {
+		var p TrendPoint
+		var start sql.NullTime
+		if err := rows.Scan(&start, &p.Total, &p.Correct); err != nil {
+			return nil, err
+		}
+		if start.Valid {
+			p.Period = start.Time.Format(time.RFC3339)
+		}
+		p.Accuracy = percentage(p.Correct, p.Total)
+		trend = append(trend, p)
+	}
+	return trend, rows.Err()
+}
+
+// Categorías con menor precisión (solo las que tienen suficientes intentos)
+
+func weakestCategories(categories []AccuracyStat, limit int) []AccuracyStat {
+	var candidates []AccuracyStat
+	for _, c := range categories {
+		if c.Total >= minAttemptsWeakCategory {
+			candidates = append(candidates, c)
+		}
+	}
+	// Orden por precisión ascendente; a igual precisión, más intentos primero
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Accuracy != candidates[j].Accuracy {
+			return candidates[i].Accuracy < candidates[j].Accuracy
+		}
+		return candidates[i].Total > candidates[j].Total
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	if candidates == nil {
+		candidates = []AccuracyStat{}
+	}
+	return candidates
+}
+
+func percentage(correct, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(correct) / float64(total) * 100
+}
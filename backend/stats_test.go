@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPercentage(t *testing.T) {
+	tests := []struct {
+		correct, total int
+		want           float64
+	}{
+		{0, 0, 0},
+		{0, 4, 0},
+		{1, 4, 25},
+		{3, 3, 100},
+	}
+	for _, tt := range tests {
+		if got := percentage(tt.correct, tt.total); got != tt.want {
+			t.Errorf("percentage(%d, %d) = %v, want %v", tt.correct, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestWeakestCategories(t *testing.T) {
+	stat := func(name string, correct, total int) AccuracyStat {
+		return AccuracyStat{Name: name, Correct: correct, Total: total, Accuracy: percentage(correct, total)}
+	}
+	categories := []AccuracyStat{
+		stat("Arte", 4, 5),
+		stat("Historia", 1, 10),
+		stat("Ciencia", 1, 2), // menos de minAttemptsWeakCategory: se ignora
+		stat("Deportes", 1, 5),
+		stat("Geografía", 2, 10),
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{"orden por precisión", 3, []string{"Historia", "Geografía", "Deportes"}},
+		{"límite", 1, []string{"Historia"}},
+		{"límite mayor que candidatos", 10, []string{"Historia", "Geografía", "Deportes", "Arte"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range weakestCategories(categories, tt.limit) {
+				got = append(got, c.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("weakestCategories = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("empate: más intentos primero", func(t *testing.T) {
+		got := weakestCategories([]AccuracyStat{stat("A", 1, 5), stat("B", 2, 10)}, 2)
+		if got[0].Name != "B" {
+			t.Errorf("primera = %s, want B", got[0].Name)
+		}
+	})
+
+	t.Run("sin candidatos devuelve slice vacío", func(t *testing.T) {
+		got := weakestCategories([]AccuracyStat{stat("A", 0, 1)}, 3)
+		if got == nil || len(got) != 0 {
+			t.Errorf("weakestCategories = %#v, want []", got)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Tokens firmados con HMAC-SHA256 para un único propósito (verificar el email,
+// completar el segundo factor...). No se guardan en la base de datos y cada
+// propósito usa su propia clave derivada de JWT_SECRET, así que un token nunca
+// sirve para otro propósito ni como JWT de sesión.
+// Formato: base64url(payload JSON) + "." + base64url(firma).
+
+const (
+	purposeEmailVerification = "email-verification"
+	purposeMFAChallenge      = "mfa-challenge"
+	purposeOIDCLogin         = "oidc-login"
+)
+
+type purposeClaims struct {
+	UserID  int    `json:"uid,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Expires int64  `json:"exp"`
+
+	// Estado del login OIDC en curso (cookie entre /auth/oidc/login y el callback)
+	State    string `json:"state,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Verifier string `json:"verifier,omitempty"`
+}
+
+var errPurposeTokenInvalid = errors.New("token inválido o caducado")
+
+func purposeKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(AppConfig.JWTSecret))
+	mac.Write([]byte("quizforge-" + purpose))
+	return mac.Sum(nil)
+}
+
+func signPurposeToken(purpose string, claims purposeClaims, ttl time.Duration) (string, error) {
+	claims.Expires = time.Now().Add(ttl).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, purposeKey(purpose))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func parsePurposeToken(purpose, token string) (purposeClaims, error) {
+	var claims purposeClaims
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errPurposeTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return claims, errPurposeTokenInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return claims, errPurposeTokenInvalid
+	}
+	mac := hmac.New(sha256.New, purposeKey(purpose))
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return claims, errPurposeTokenInvalid
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errPurposeTokenInvalid
+	}
+	if time.Now().Unix() > claims.Expires {
+		return claims, errPurposeTokenInvalid
+	}
+	return claims, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePurposeToken(t *testing.T) {
+	saved := AppConfig.JWTSecret
+	AppConfig.JWTSecret = "secreto-de-pruebas-de-al-menos-32-caracteres"
+	t.Cleanup(func() { AppConfig.JWTSecret = saved })
+
+	token, err := signPurposeToken(purposeEmailVerification, purposeClaims{UserID: 7, Email: "ana@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("válido", func(t *testing.T) {
+		claims, err := parsePurposeToken(purposeEmailVerification, token)
+		if err != nil {
+			t.Fatalf("parsePurposeToken: %v", err)
+		}
+		if claims.UserID != 7 || claims.Email != "ana@example.com" {
+			t.Errorf("claims = %+v", claims)
+		}
+	})
+
+	expired, err := signPurposeToken(purposeEmailVerification, purposeClaims{UserID: 7}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"uid":1,"exp":9999999999}`)) + "." + sig
+
+	invalid := []struct {
+		name    string
+		purpose string
+		token   string
+	}{
+		{"otro propósito", purposeMFAChallenge, token},
+		{"caducado", purposeEmailVerification, expired},
+		{"payload alterado", purposeEmailVerification, forged},
+		{"sin firma", purposeEmailVerification, payload},
+		{"firma vacía", purposeEmailVerification, payload + "."},
+		{"base64 inválido", purposeEmailVerification, "***." + sig},
+		{"vacío", purposeEmailVerification, ""},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePurposeToken(tt.purpose, tt.token); err != errPurposeTokenInvalid {
+				t.Errorf("parsePurposeToken err = %v, want errPurposeTokenInvalid", err)
+			}
+		})
+	}
+
+	t.Run("otra clave", func(t *testing.T) {
+		AppConfig.JWTSecret = "otro-secreto-de-pruebas-de-al-menos-32-caracteres"
+		defer func() { AppConfig.JWTSecret = "secreto-de-pruebas-de-al-menos-32-caracteres" }()
+		if _, err := parsePurposeToken(purposeEmailVerification, token); err != errPurposeTokenInvalid {
+			t.Errorf("parsePurposeToken err = %v, want errPurposeTokenInvalid", err)
+		}
+	})
+}
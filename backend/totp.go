@@ -0,0 +1,518 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parámetros TOTP (RFC 6238) compatibles con las apps de autenticación habituales
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // pasos de 30 s aceptados antes y después del actual
+	totpSecretBytes   = 20
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func roleRequiresTOTP(role string) bool {
+	for _, r := range AppConfig.TOTPRequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Código de un paso (RFC 4226 con contador = tiempo / periodo)
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// Comprobar un código; devuelve el paso que coincidió para impedir que se reutilice
+
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	current := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI otpauth:// para generar el código QR en el cliente
+
+func totpProvisioningURI(secret, account string) string {
+	issuer := AppConfig.TOTPIssuer
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+// Códigos de recuperación: se muestran una sola vez y se guarda su hash
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reemplazar los códigos de recuperación del usuario por otros nuevos
+
+func regenerateRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = raw[:4] + "-" + raw[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])`, userID, pq.Array(hashes)); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Comprobar un segundo factor (código TOTP o de recuperación) y consumirlo
+
+type totpState struct {
+	Secret   sql.NullString
+	Enabled  bool
+	LastStep sql.NullInt64
+}
+
+func loadTOTPState(userID int) (totpState, error) {
+	var s totpState
+	err := DB.QueryRow(`SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = $1`, userID).
+		Scan(&s.Secret, &s.Enabled, &s.LastStep)
+	return s, err
+}
+
+func checkSecondFactor(userID int, state totpState, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := verifyTOTP(state.Secret.String, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Un código ya usado (mismo paso o anterior) no vuelve a valer
+		res, err := DB.Exec(`
+			UPDATE users SET totp_last_step = $2
+			WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`, userID, step)
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	}
+	if recoveryCode != "" {
+		res, err := DB.Exec(`
+			UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	}
+	return false, nil
+}
+
+// Respuesta de login cuando falta el segundo factor
+
+func newMFAChallenge(userID int) (map[string]interface{}, error) {
+	challenge, err := signPurposeToken(purposeMFAChallenge, purposeClaims{UserID: userID}, AppConfig.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"mfaRequired":    true,
+		"challengeToken": challenge,
+		"expiresIn":      int(AppConfig.MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// Completar el login con el segundo factor usando el token de desafío de /login
+
+func LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	claims, err := parsePurposeToken(purposeMFAChallenge, req.ChallengeToken)
+	if err != nil {
+		writeFieldError(w, r, http.StatusUnauthorized, "MFA_CHALLENGE_INVALID", "challengeToken")
+		return
+	}
+
+	ipKey, accountKey := loginIPKey(r), loginAccountKey(claims.UserID)
+	if rejectLockedLogin(w, r, ipKey) || rejectLockedLogin(w, r, accountKey) {
+		return
+	}
+
+	var user User
+	err = DB.QueryRow(`SELECT id, email, username, role, email_verified_at IS NOT NULL FROM users WHERE id = $1 AND deleted_at IS NULL`, claims.UserID).
+		Scan(&user.ID, &user.Email, &user.Username, &user.Role, &user.EmailVerified)
+	if err != nil {
+		writeFieldError(w, r, http.StatusUnauthorized, "MFA_CHALLENGE_INVALID", "challengeToken")
+		return
+	}
+	state, err := loadTOTPState(user.ID)
+	if err != nil || !state.Enabled {
+		writeFieldError(w, r, http.StatusUnauthorized, "MFA_CHALLENGE_INVALID", "challengeToken")
+		return
+	}
+
+	ok, err := checkSecondFactor(user.ID, state, req.Code, req.RecoveryCode)
+	if err != nil {
+		requestLogger(r).Error("Error al comprobar el segundo factor", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "MFA_CHECK_FAILED")
+		return
+	}
+	if !ok {
+		if err := recordLoginFailure(r, ipKey, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeError(w, r, http.StatusUnauthorized, "MFA_CODE_INVALID")
+		return
+	}
+	if rejectInactiveAccount(w, r, user.ID) {
+		return
+	}
+	user.TwoFactorEnabled = true
+	issueSession(w, r, user, true)
+}
+
+// Estado del 2FA del usuario autenticado
+
+func GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var enabled bool
+	var role string
+	var remaining int
+	err = DB.QueryRow(`
+		SELECT u.totp_enabled_at IS NOT NULL, u.role,
+		       (SELECT COUNT(*) FROM user_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		FROM users u WHERE u.id = $1`, userID).Scan(&enabled, &role, &remaining)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                enabled,
+		"required":               roleRequiresTOTP(role),
+		"recoveryCodesRemaining": remaining,
+	})
+}
+
+// Iniciar la activación: genera un secreto pendiente y su URI de aprovisionamiento
+
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var email string
+	var enabled bool
+	if err := DB.QueryRow(`SELECT email, totp_enabled_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&email, &enabled); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if enabled {
+		writeError(w, r, http.StatusConflict, "TOTP_ALREADY_ENABLED")
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	if _, err := DB.Exec(`UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1`, userID, secret); err != nil {
+		requestLogger(r).Error("Error al guardar secreto TOTP", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":     secret,
+		"otpauthUri": totpProvisioningURI(secret, email),
+		"digits":     totpDigits,
+		"period":     totpPeriod,
+	})
+}
+
+// Confirmar la activación con un código de la app; devuelve los códigos de recuperación
+
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "MFA_CODE_REQUIRED", "code")
+		return
+	}
+
+	state, err := loadTOTPState(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if state.Enabled {
+		writeError(w, r, http.StatusConflict, "TOTP_ALREADY_ENABLED")
+		return
+	}
+	if !state.Secret.Valid {
+		writeError(w, r, http.StatusBadRequest, "TOTP_NOT_SETUP")
+		return
+	}
+	step, ok := verifyTOTP(state.Secret.String, req.Code, time.Now())
+	if !ok {
+		writeFieldError(w, r, http.StatusBadRequest, "MFA_CODE_INVALID", "code")
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = $2 WHERE id = $1`, userID, step); err != nil {
+		requestLogger(r).Error("Error al activar TOTP", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	codes, err := regenerateRecoveryCodes(tx, userID)
+	if err != nil {
+		requestLogger(r).Error("Error al generar códigos de recuperación", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Verificación en dos pasos activada",
+		"recoveryCodes": codes,
+	})
+}
+
+// Desactivar el 2FA: exige la contraseña y un segundo factor
+
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+
+	accountKey := loginAccountKey(userID)
+	if rejectLockedLogin(w, r, accountKey) {
+		return
+	}
+
+	var hash, role string
+	if err := DB.QueryRow(`SELECT password, role FROM users WHERE id = $1`, userID).Scan(&hash, &role); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if roleRequiresTOTP(role) {
+		writeError(w, r, http.StatusForbidden, "TOTP_REQUIRED_FOR_ROLE")
+		return
+	}
+	state, err := loadTOTPState(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if !state.Enabled {
+		writeError(w, r, http.StatusConflict, "TOTP_NOT_ENABLED")
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+		if err := recordLoginFailure(r, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeFieldError(w, r, http.StatusForbidden, "CURRENT_PASSWORD_INVALID", "password")
+		return
+	}
+	ok, err := checkSecondFactor(userID, state, req.Code, req.RecoveryCode)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "MFA_CHECK_FAILED")
+		return
+	}
+	if !ok {
+		if err := recordLoginFailure(r, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeError(w, r, http.StatusForbidden, "MFA_CODE_INVALID")
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_DISABLE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1`, userID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_DISABLE_FAILED")
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_DISABLE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_DISABLE_FAILED")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Verificación en dos pasos desactivada"})
+}
+
+// Generar nuevos códigos de recuperación (invalida los anteriores)
+
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "MFA_CODE_REQUIRED", "code")
+		return
+	}
+
+	accountKey := loginAccountKey(userID)
+	if rejectLockedLogin(w, r, accountKey) {
+		return
+	}
+	state, err := loadTOTPState(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if !state.Enabled {
+		writeError(w, r, http.StatusConflict, "TOTP_NOT_ENABLED")
+		return
+	}
+	ok, err := checkSecondFactor(userID, state, req.Code, "")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "MFA_CHECK_FAILED")
+		return
+	}
+	if !ok {
+		if err := recordLoginFailure(r, accountKey); err != nil {
+			requestLogger(r).Error("Error al registrar login fallido", "error", err)
+		}
+		writeFieldError(w, r, http.StatusForbidden, "MFA_CODE_INVALID", "code")
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	codes, err := regenerateRecoveryCodes(tx, userID)
+	if err != nil {
+		requestLogger(r).Error("Error al generar códigos de recuperación", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TOTP_SETUP_FAILED")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
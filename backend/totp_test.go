@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Vectores de RFC 6238 (SHA-1) truncados a 6 dígitos
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(t=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"paso actual", secret, totpCode(key, step), step, true},
+		{"paso anterior", secret, totpCode(key, step-1), step - 1, true},
+		{"paso siguiente", secret, totpCode(key, step+1), step + 1, true},
+		{"fuera de la tolerancia", secret, totpCode(key, step-2), 0, false},
+		{"con espacios", secret, "005 924", step, true},
+		{"secreto en minúsculas", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "005924", step, true},
+		{"código incorrecto", secret, "000000", 0, false},
+		{"secreto inválido", "no-es-base32!", "005924", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := verifyTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("verifyTOTP = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Idioma original de las preguntas importadas desde OpenTDB
+const defaultSourceLang = "en"
+
+// Normalizar una etiqueta de idioma a su subetiqueta principal ("es-MX" -> "es")
+
+func normalizeLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
+
+// Idioma solicitado: parámetro lang o, si no existe, la cabecera Accept-Language
+// (se respeta el orden por calidad q). Devuelve "" si no se pidió ninguno.
+
+func requestLang(r *http.Request) string {
+	if lang := normalizeLang(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+	return preferredLangs(r.Header.Get("Accept-Language"))[0]
+}
+
+// Idiomas de Accept-Language ordenados por preferencia; siempre devuelve al menos un elemento
+
+func preferredLangs(header string) []string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var langs []langQ
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := normalizeLang(fields[0])
+		if lang == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	out := []string{}
+	for _, l := range langs {
+		out = append(out, l.lang)
+	}
+	if len(out) == 0 {
+		out = append(out, "")
+	}
+	return out
+}
+
+// Reemplazar el contenido de las preguntas por su traducción cuando existe;
+// si no, se mantiene el idioma original
+
+func applyTranslations(questions []Question, lang string) error {
+	if lang == "" || len(questions) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(questions))
+	for i, q := range questions {
+		ids[i] = int64(q.ID)
+	}
+
+	rows, err := DB.Query(`
+		SELECT question_id, question, correct_answer, incorrect_answers, COALESCE(correct_answers, '{}')
+		FROM question_translations
+		WHERE lang = $1 AND question_id = ANY($2)`, lang, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	translated := map[int]QuestionTranslation{}
+	for rows.Next() {
+		var t QuestionTranslation
+		if err := rows.Scan(&t.QuestionID, &t.Question, &t.CorrectAnswer, pq.Array(&t.IncorrectAnswers), pq.Array(&t.CorrectAnswers)); err != nil {
+			return err
+		}
+		translated[t.QuestionID] = t
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range questions {
+		q := &questions[i]
+		t, ok := translated[q.ID]
+		if !ok {
+			continue
+		}
+		q.Question = t.Question
+		q.CorrectAnswer = t.CorrectAnswer
+		if len(t.IncorrectAnswers) > 0 {
+			q.IncorrectAnswers = t.IncorrectAnswers
+		}
+		if len(t.CorrectAnswers) > 0 {
+			q.CorrectAnswers = t.CorrectAnswers
+		}
+		q.Lang = lang
+	}
+	return nil
+}
+
+// Corregir una respuesta aceptando el idioma original o cualquiera de sus traducciones
+
+func gradeAnswer(key Question, selected string, selectedMulti []string) bool {
+	if key.Grade(selected, selectedMulti) {
+		return true
+	}
+	rows, err := DB.Query(`
+		SELECT correct_answer, COALESCE(correct_answers, '{}')
+		FROM question_translations
+		WHERE question_id = $1`, key.ID)
+	if err != nil {
+		slog.Warn("No se pudieron cargar traducciones para corregir", "error", err)
+		return false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := key
+		if err := rows.Scan(&t.CorrectAnswer, pq.Array(&t.CorrectAnswers)); err != nil {
+			return false
+		}
+		if key.Type == QuestionText {
+			// Las alternativas del idioma original siguen siendo válidas
+			t.CorrectAnswers = append(t.CorrectAnswers, key.CorrectAnswers...)
+		}
+		if t.Grade(selected, selectedMulti) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listar las traducciones de una pregunta (solo admin)
+
+func GetQuestionTranslations(w http.ResponseWriter, r *http.Request) {
+	questionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "QUESTION_ID_INVALID")
+		return
+	}
+
+	rows, err := DB.Query(`
+		SELECT question_id, lang, question, correct_answer, incorrect_answers, COALESCE(correct_answers, '{}')
+		FROM question_translations
+		WHERE question_id = $1
+		ORDER BY lang`, questionID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATIONS_FETCH_FAILED")
+		return
+	}
+	defer rows.Close()
+
+	translations := []QuestionTranslation{}
+	for rows.Next() {
+		var t QuestionTranslation
+		if err := rows.Scan(&t.QuestionID, &t.Lang, &t.Question, &t.CorrectAnswer, pq.Array(&t.IncorrectAnswers), pq.Array(&t.CorrectAnswers)); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "TRANSLATIONS_FETCH_FAILED")
+			return
+		}
+		translations = append(translations, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(translations)
+}
+
+// Crear o editar la traducción de una pregunta a un idioma (solo admin)
+
+func UpsertQuestionTranslation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	questionID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "QUESTION_ID_INVALID")
+		return
+	}
+	lang := normalizeLang(vars["lang"])
+	if lang == "" {
+		writeError(w, r, http.StatusBadRequest, "LANG_INVALID")
+		return
+	}
+
+	var t QuestionTranslation
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	if strings.TrimSpace(t.Question) == "" || strings.TrimSpace(t.CorrectAnswer) == "" {
+		writeError(w, r, http.StatusBadRequest, "TRANSLATION_FIELDS_REQUIRED")
+		return
+	}
+
+	// Las opciones traducidas deben corresponder una a una con las originales
+	var sourceIncorrect, sourceCorrect []string
+	err = DB.QueryRow(`SELECT incorrect_answers, COALESCE(correct_answers, '{}') FROM questions WHERE id = $1`, questionID).
+		Scan(pq.Array(&sourceIncorrect), pq.Array(&sourceCorrect))
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "QUESTION_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "QUESTION_FETCH_FAILED")
+		return
+	}
+	if len(t.IncorrectAnswers) != len(sourceIncorrect) || len(t.CorrectAnswers) != len(sourceCorrect) {
+		writeError(w, r, http.StatusBadRequest, "TRANSLATION_OPTIONS_MISMATCH")
+		return
+	}
+	// Solo las preguntas multi_select tienen correct_answers
+	if len(sourceCorrect) > 0 && !validMultiOptions(t.CorrectAnswers, t.IncorrectAnswers) {
+		writeError(w, r, http.StatusBadRequest, "MULTI_OPTION_INVALID")
+		return
+	}
+	if t.IncorrectAnswers == nil {
+		t.IncorrectAnswers = []string{}
+	}
+
+	t.QuestionID = questionID
+	t.Lang = lang
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_SAVE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO question_translations (question_id, lang, question, correct_answer, incorrect_answers, correct_answers, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (question_id, lang)
+		DO UPDATE SET question = $3, correct_answer = $4, incorrect_answers = $5, correct_answers = $6, updated_at = CURRENT_TIMESTAMP`,
+		questionID, lang, t.Question, t.CorrectAnswer, pq.Array(t.IncorrectAnswers), pq.Array(t.CorrectAnswers)); err != nil {
+		requestLogger(r).Error("Error al guardar traducción", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_SAVE_FAILED")
+		return
+	}
+	if err := recordAudit(tx, r, auditTranslationUpsert, auditTargetTranslation, strconv.Itoa(questionID)+"/"+lang, nil, t); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_SAVE_FAILED")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+// Eliminar la traducción de una pregunta (solo admin)
+
+func DeleteQuestionTranslation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	questionID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "QUESTION_ID_INVALID")
+		return
+	}
+	lang := normalizeLang(vars["lang"])
+	tx, err := DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_DELETE_FAILED")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM question_translations WHERE question_id = $1 AND lang = $2`, questionID, lang)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_DELETE_FAILED")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, "TRANSLATION_NOT_FOUND")
+		return
+	}
+	if err := recordAudit(tx, r, auditTranslationDelete, auditTargetTranslation, strconv.Itoa(questionID)+"/"+lang, nil, nil); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "AUDIT_WRITE_FAILED")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "TRANSLATION_DELETE_FAILED")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Traducción eliminada"})
+}
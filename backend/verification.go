@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// Restricciones que UNVERIFIED_RESTRICT puede aplicar a cuentas sin verificar
+const (
+	restrictLogin = "login" // no pueden iniciar sesión
+	restrictQuiz  = "quiz"  // no pueden guardar respuestas ni usar sesiones, repaso o modo adaptativo
+)
+
+func validUnverifiedRestriction(v string) bool {
+	return v == restrictLogin || v == restrictQuiz
+}
+
+func unverifiedRestricted(action string) bool {
+	for _, a := range AppConfig.UnverifiedRestrict {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Validar el formato de un email: una sola dirección, sin nombre visible
+
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.Contains(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// Enviar el correo de verificación
+
+func sendVerificationEmail(r *http.Request, userID int, email string) error {
+	// El token incluye el email, así que deja de valer si el email cambia
+	token, err := signPurposeToken(purposeEmailVerification, purposeClaims{UserID: userID, Email: email}, AppConfig.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimRight(AppConfig.AppBaseURL, "/") + "/verify-email?token=" + token
+	return mailer.Send(r.Context(), MailMessage{
+		To:      email,
+		Subject: "Verifica tu email de QuizForge",
+		Body: fmt.Sprintf("Hola,\n\nConfirma tu email abriendo este enlace:\n\n%s\n\n"+
+			"El enlace caduca en %s. Si no creaste una cuenta en QuizForge, ignora este correo.\n",
+			link, AppConfig.EmailVerificationTTL),
+	})
+}
+
+// Verificar el email con el token del enlace (?token= o {"token"})
+
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "INVALID_REQUEST")
+			return
+		}
+		token = req.Token
+	}
+
+	claims, err := parsePurposeToken(purposeEmailVerification, token)
+	if err != nil {
+		writeFieldError(w, r, http.StatusBadRequest, "VERIFICATION_TOKEN_INVALID", "token")
+		return
+	}
+
+	// Solo verifica si el email sigue siendo el del token; repetir es inofensivo
+	res, err := DB.Exec(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND email = $2`, claims.UserID, claims.Email)
+	if err != nil {
+		requestLogger(r).Error("Error al verificar email", "error", err)
+		writeError(w, r, http.StatusInternalServerError, "EMAIL_VERIFY_FAILED")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeFieldError(w, r, http.StatusBadRequest, "VERIFICATION_TOKEN_INVALID", "token")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "Email verificado"})
+}
+
+// Reenviar el correo de verificación. Siempre responde 202 para no revelar
+// qué emails están registrados o verificados.
+
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		writeFieldError(w, r, http.StatusBadRequest, "EMAIL_REQUIRED", "email")
+		return
+	}
+
+	var userID int
+	var email string
+	err := DB.QueryRow(`SELECT id, email FROM users WHERE email = $1 AND email_verified_at IS NULL AND deleted_at IS NULL`,
+		strings.TrimSpace(req.Email)).Scan(&userID, &email)
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+		return
+	}
+	if err == nil {
+		if err := sendVerificationEmail(r, userID, email); err != nil {
+			requestLogger(r).Error("Error al enviar correo de verificación", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"message": "Si la cuenta existe y no está verificada, recibirás un nuevo enlace",
+	})
+}
+
+// Middleware que aplica UNVERIFIED_RESTRICT a una acción; va dentro de AuthMiddleware
+
+func RequireVerifiedEmail(next http.HandlerFunc, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !unverifiedRestricted(action) {
+			next(w, r)
+			return
+		}
+		userID, err := userIDFromRequest(r)
+		if err != nil {
+			writeError(w, r, http.StatusUnauthorized, "TOKEN_INVALID")
+			return
+		}
+		var verified bool
+		if err := DB.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&verified); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "USER_READ_FAILED")
+			return
+		}
+		if !verified {
+			writeError(w, r, http.StatusForbidden, "EMAIL_NOT_VERIFIED")
+			return
+		}
+		next(w, r)
+	}
+}
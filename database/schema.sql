@@ -1,5 +1,5 @@
 -- Schema SQL para QuizForge
--- Tablas: users, questions, attempts, attempt_summary
+-- Tablas: users, questions, attempts, attempt_summary, review_cards, quiz_sessions, user_skill, question_translations, password_resets, user_recovery_codes, user_identities, api_keys, audit_log
 
 -- Usuarios
 CREATE TABLE IF NOT EXISTS users (
@@ -8,9 +8,31 @@ CREATE TABLE IF NOT EXISTS users (
 	password TEXT NOT NULL,
 	username TEXT,
 	role TEXT NOT NULL DEFAULT 'user',
-	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	-- NULL mientras el email no esté verificado
+	email_verified_at TIMESTAMP,
+	-- Verificación en dos pasos: secreto base32, activación y último paso TOTP usado
+	totp_secret TEXT,
+	totp_enabled_at TIMESTAMP,
+	totp_last_step BIGINT,
+	-- Perfil
+	display_name TEXT,
+	avatar_url TEXT,
+	preferred_lang TEXT,
+	preferred_categories TEXT[],
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	-- Borrado lógico: la cuenta deja de poder entrar pero se conserva su historial
+	deleted_at TIMESTAMP,
+	-- Datos personales borrados (cuenta anonimizada; los intentos se conservan)
+	erased_at TIMESTAMP,
+	-- Suspensión: sin suspended_until es indefinida (baneo)
+	suspended_at TIMESTAMP,
+	suspended_until TIMESTAMP,
+	suspension_reason TEXT,
+	suspended_by INTEGER REFERENCES users(id) ON DELETE SET NULL
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (LOWER(username));
+
 -- Preguntas
 CREATE TABLE IF NOT EXISTS questions (
 	id SERIAL PRIMARY KEY,
@@ -19,9 +41,31 @@ CREATE TABLE IF NOT EXISTS questions (
 	incorrect_answers TEXT[] NOT NULL,
 	categoria TEXT,
 	dificultad TEXT,
+	rating DOUBLE PRECISION,
+	-- multiple, boolean, multi_select, text o numeric
+	type TEXT NOT NULL DEFAULT 'multiple',
+	-- respuestas correctas de multi_select o alternativas aceptadas en text
+	correct_answers TEXT[],
+	-- margen aceptado en preguntas numeric
+	tolerance DOUBLE PRECISION,
+	-- idioma original del contenido
+	source_lang TEXT NOT NULL DEFAULT 'en',
 	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Sesiones de quiz (límites de tiempo validados en el servidor)
+CREATE TABLE IF NOT EXISTS quiz_sessions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	mode TEXT NOT NULL,
+	total_limit_seconds INTEGER NOT NULL DEFAULT 0,
+	per_question_limit_seconds INTEGER NOT NULL DEFAULT 0,
+	-- Preguntas de la sesión: solo se aceptan respuestas a estas, una vez cada una
+	question_ids INTEGER[] NOT NULL DEFAULT '{}',
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
 -- Intentos individuales
 CREATE TABLE IF NOT EXISTS attempts (
 	id SERIAL PRIMARY KEY,
@@ -29,7 +73,10 @@ CREATE TABLE IF NOT EXISTS attempts (
 	question_id INTEGER REFERENCES questions(id) ON DELETE SET NULL,
 	selected_answer TEXT,
 	is_correct BOOLEAN,
-	answered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	answered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	session_id INTEGER REFERENCES quiz_sessions(id) ON DELETE SET NULL,
+	time_taken_ms BIGINT,
+	timed_out BOOLEAN DEFAULT false
 );
 
 CREATE INDEX IF NOT EXISTS idx_attempts_user ON attempts(user_id);
@@ -44,3 +91,117 @@ CREATE TABLE IF NOT EXISTS attempt_summary (
 	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Tarjetas de repaso espaciado (SM-2) por usuario y pregunta fallada
+CREATE TABLE IF NOT EXISTS review_cards (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	question_id INTEGER REFERENCES questions(id) ON DELETE CASCADE,
+	repetitions INTEGER NOT NULL DEFAULT 0,
+	interval_days INTEGER NOT NULL DEFAULT 1,
+	ease_factor DOUBLE PRECISION NOT NULL DEFAULT 2.5,
+	due_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_reviewed_at TIMESTAMP,
+	UNIQUE (user_id, question_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_review_cards_due ON review_cards(user_id, due_at);
+
+-- Rating de habilidad (Elo) por usuario para el modo adaptativo
+CREATE TABLE IF NOT EXISTS user_skill (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	rating DOUBLE PRECISION NOT NULL DEFAULT 1200,
+	answered INTEGER NOT NULL DEFAULT 0,
+	-- Última pregunta servida por /quiz/adaptive/next; es la única que se puede responder
+	pending_question_id INTEGER REFERENCES questions(id) ON DELETE SET NULL,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Traducciones del texto y respuestas de cada pregunta por idioma
+CREATE TABLE IF NOT EXISTS question_translations (
+	id SERIAL PRIMARY KEY,
+	question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+	lang TEXT NOT NULL,
+	question TEXT NOT NULL,
+	correct_answer TEXT NOT NULL,
+	incorrect_answers TEXT[] NOT NULL,
+	correct_answers TEXT[],
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (question_id, lang)
+);
+
+-- Enlaces de un solo uso para restablecer la contraseña (solo se guarda el hash del token)
+CREATE TABLE IF NOT EXISTS password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash TEXT UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Códigos de recuperación de la verificación en dos pasos (solo se guarda el hash)
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, code_hash)
+);
+
+-- Identidades de proveedores OpenID Connect enlazadas a usuarios (provider = issuer, subject = sub)
+CREATE TABLE IF NOT EXISTS user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_login_at TIMESTAMP,
+	UNIQUE (provider, subject)
+);
+
+-- Claves de API para integraciones (solo se guarda el hash SHA-256 de la clave)
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT[] NOT NULL,
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+
+-- Auditoría de acciones administrativas (solo inserción; sin claves foráneas
+-- para que las entradas sobrevivan a la purga de usuarios y claves)
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	-- usuario o clave de API que hizo el cambio
+	actor_id INTEGER,
+	actor_key_id INTEGER,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id TEXT,
+	before JSONB,
+	after JSONB,
+	ip TEXT,
+	request_id TEXT,
+	-- Con zona horaria: los filtros from/to de /admin/audit son instantes RFC 3339
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+	BEGIN RAISE EXCEPTION 'audit_log es de solo inserción'; END $$;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
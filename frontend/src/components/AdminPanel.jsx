@@ -1,5 +1,6 @@
 import { useEffect, useState } from "react";
 import { Link } from "react-router-dom";
+import { errorMessage } from "../services/api";
 
 const BASE_URL = process.env.REACT_APP_API_URL || "http://localhost:8080";
 
@@ -20,8 +21,7 @@ export default function AdminPanel() {
         headers: { Authorization: `Bearer ${token}` },
       });
       if (!res.ok) {
-        const text = await res.text().catch(() => "");
-        throw new Error(text || `Error al obtener usuarios (status ${res.status})`);
+        throw new Error(await errorMessage(res, `Error al obtener usuarios (status ${res.status})`));
       }
       const data = await res.json();
       setUsers(Array.isArray(data) ? data : []);
@@ -1,5 +1,12 @@
 const BASE_URL = process.env.REACT_APP_API_URL || "http://localhost:8080";
 
+// Mensaje legible de una respuesta de error: { error: { code, message, status } }
+
+export async function errorMessage(res, fallback) {
+  const body = await res.json().catch(() => null);
+  return body?.error?.message || fallback;
+}
+
 
 // Preguntas con filtros
 
@@ -34,10 +41,7 @@ export async function saveAttemptAnswers(attempts, token) {
     },
     body: JSON.stringify(attempts),
   });
-  if (!res.ok) {
-    const text = await res.text().catch(() => "");
-    throw new Error(text || "Error al guardar intentos");
-  }
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al guardar intentos"));
   return res.json();
 }
 
@@ -57,7 +61,113 @@ export async function register(email, username, password) {
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ email, username, password }), 
   });
-  if (!res.ok) throw new Error("Error al registrar usuario");
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al registrar usuario"));
+  return res.json();
+}
+
+
+// Verificación de email
+
+export async function verifyEmail(token) {
+  const res = await fetch(`${BASE_URL}/verify-email`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al verificar el email"));
+  return res.json();
+}
+
+export async function resendVerificationEmail(email) {
+  const res = await fetch(`${BASE_URL}/verify-email/resend`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al reenviar el correo"));
+  return res.json();
+}
+
+
+// Perfil del usuario autenticado
+
+async function profileRequest(method, body, fallback) {
+  const token = localStorage.getItem("token");
+  const res = await fetch(`${BASE_URL}/user/me`, {
+    method,
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, fallback));
+  return res.json();
+}
+
+export const getProfile = () => profileRequest("GET", null, "Error al obtener el perfil");
+export const updateProfile = (changes) => profileRequest("PATCH", changes, "Error al actualizar el perfil");
+export const deleteAccount = (confirmation) => profileRequest("DELETE", confirmation, "Error al eliminar la cuenta");
+
+// Datos personales: descarga (Blob JSON o ZIP) y borrado definitivo
+
+export async function exportMyData(format = "json") {
+  const token = localStorage.getItem("token");
+  const res = await fetch(`${BASE_URL}/user/export?format=${format}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al exportar los datos"));
+  return res.blob();
+}
+
+export async function eraseMyData(confirmation) {
+  const token = localStorage.getItem("token");
+  const res = await fetch(`${BASE_URL}/user/me/erase`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify(confirmation),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al borrar los datos"));
+  return res.json();
+}
+
+
+// Contraseñas
+
+export async function changePassword(currentPassword, newPassword) {
+  const token = localStorage.getItem("token");
+  const res = await fetch(`${BASE_URL}/user/password`, {
+    method: "PUT",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ currentPassword, newPassword }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al cambiar la contraseña"));
+  return res.json();
+}
+
+export async function forgotPassword(email) {
+  const res = await fetch(`${BASE_URL}/password/forgot`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al solicitar el enlace"));
+  return res.json();
+}
+
+export async function resetPassword(token, password) {
+  const res = await fetch(`${BASE_URL}/password/reset`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token, password }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Error al restablecer la contraseña"));
   return res.json();
 }
 
@@ -70,11 +180,67 @@ export async function login(email, password) {
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ email, password }),
   });
-  if (!res.ok) throw new Error("Credenciales inválidas");
-  return res.json(); // devuelve { token, role, user }
+  if (!res.ok) throw new Error(await errorMessage(res, "Credenciales inválidas"));
+  return res.json(); // devuelve { token, role, user } o { mfaRequired, challengeToken }
+}
+
+// Login con proveedor externo (OIDC)
+export async function getOIDCInfo() {
+  const res = await fetch(`${BASE_URL}/auth/oidc`);
+  if (!res.ok) return { enabled: false };
+  return res.json();
+}
+
+// Navegar (no fetch) a esta URL inicia el login; el backend vuelve a /login/oidc
+export const oidcLoginUrl = () => `${BASE_URL}/auth/oidc/login`;
+
+// Leer el resultado que el backend deja en el fragmento de /login/oidc
+export function parseOIDCResult(hash = window.location.hash) {
+  const params = new URLSearchParams(hash.replace(/^#/, ""));
+  if (params.get("error")) throw new Error(params.get("message") || params.get("error"));
+  return Object.fromEntries(params);
+}
+
+// Segundo paso del login con código TOTP o de recuperación
+export async function loginSecondFactor(challengeToken, { code, recoveryCode }) {
+  const res = await fetch(`${BASE_URL}/login/2fa`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ challengeToken, code, recoveryCode }),
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, "Código de verificación incorrecto"));
+  return res.json();
 }
 
 
+// Verificación en dos pasos del usuario autenticado
+
+async function twoFactorRequest(path, method, body, fallback) {
+  const token = localStorage.getItem("token");
+  const res = await fetch(`${BASE_URL}/user/2fa${path}`, {
+    method,
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (!res.ok) throw new Error(await errorMessage(res, fallback));
+  return res.json();
+}
+
+export const getTwoFactorStatus = () =>
+  twoFactorRequest("", "GET", null, "Error al obtener el estado del 2FA");
+export const setupTwoFactor = () =>
+  twoFactorRequest("/setup", "POST", null, "Error al configurar el 2FA");
+export const enableTwoFactor = (code) =>
+  twoFactorRequest("/enable", "POST", { code }, "Error al activar el 2FA");
+export const disableTwoFactor = (password, { code, recoveryCode }) =>
+  twoFactorRequest("/disable", "POST", { password, code, recoveryCode }, "Error al desactivar el 2FA");
+export const regenerateRecoveryCodes = (code) =>
+  twoFactorRequest("/recovery-codes", "POST", { code }, "Error al generar códigos de recuperación");
+
+
 // Historial del usuario autenticado
 
 export async function getUserAttempts(token) {